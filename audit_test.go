@@ -0,0 +1,111 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	setupTestSuite("test_audit_log")
+
+	db.Unscoped().Delete(models.Environment{}, models.Build{})
+	db.Unscoped().Delete(models.AuditRecord{})
+	CreateTestData(db, 20)
+
+	start := time.Now().Add(-time.Second)
+
+	_, err := n.Request("environment.set", []byte(`{"id": 1, "name": "Test1", "user_name": "alice", "options": {"sync": false}}`), time.Second)
+	assert.Nil(t, err)
+
+	_, err = n.Request("build.set.status", []byte(`{"id": "uuid-2", "status": "errored", "user_name": "bob"}`), time.Second)
+	assert.Nil(t, err)
+
+	_, err = n.Request("build.set.status", []byte(`{"id": "uuid-404", "status": "done", "user_name": "bob"}`), time.Second)
+	assert.Nil(t, err)
+
+	_, err = n.Request("environment.get", []byte(`{"id": 1}`), time.Second)
+	assert.Nil(t, err)
+
+	cases := []struct {
+		Name     string
+		Query    map[string]interface{}
+		Expected []models.AuditRecord
+	}{
+		{
+			"by-actor",
+			map[string]interface{}{"actor": "alice"},
+			[]models.AuditRecord{{Subject: "environment.set", Actor: "alice", TargetType: "environment", Target: "1", EnvironmentID: 1, Change: "updated", Outcome: "success"}},
+		},
+		{
+			"by-target",
+			map[string]interface{}{"target": "uuid-2"},
+			[]models.AuditRecord{{Subject: "build.set.status", Actor: "bob", TargetType: "build", Target: "uuid-2", EnvironmentID: 2, BuildID: "uuid-2", Change: "updated", Outcome: "success"}},
+		},
+		{
+			"by-environment",
+			map[string]interface{}{"environment_id": 2},
+			[]models.AuditRecord{{Subject: "build.set.status", Actor: "bob", TargetType: "build", Target: "uuid-2", EnvironmentID: 2, BuildID: "uuid-2", Change: "updated", Outcome: "success"}},
+		},
+		{
+			"by-build",
+			map[string]interface{}{"build_id": "uuid-2"},
+			[]models.AuditRecord{{Subject: "build.set.status", Actor: "bob", TargetType: "build", Target: "uuid-2", EnvironmentID: 2, BuildID: "uuid-2", Change: "updated", Outcome: "success"}},
+		},
+		{
+			"failure",
+			map[string]interface{}{"target": "uuid-404"},
+			[]models.AuditRecord{{Subject: "build.set.status", Actor: "bob", TargetType: "build", Target: "uuid-404", Change: "unchanged", Outcome: "failure"}},
+		},
+		{
+			"by-time-range",
+			map[string]interface{}{"to": start},
+			[]models.AuditRecord{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var records []models.AuditRecord
+
+			data, _ := json.Marshal(tc.Query)
+			resp, err := n.Request("audit.find", data, time.Second)
+			assert.Nil(t, err)
+			assert.Nil(t, json.Unmarshal(resp.Data, &records))
+			assert.Equal(t, len(tc.Expected), len(records))
+
+			for i, r := range records {
+				e := tc.Expected[i]
+				assert.Equal(t, e.Subject, r.Subject)
+				assert.Equal(t, e.Actor, r.Actor)
+				assert.False(t, r.Verified)
+				assert.Equal(t, e.TargetType, r.TargetType)
+				assert.Equal(t, e.Target, r.Target)
+				assert.Equal(t, e.EnvironmentID, r.EnvironmentID)
+				assert.Equal(t, e.BuildID, r.BuildID)
+				assert.Equal(t, e.Change, r.Change)
+				assert.Equal(t, e.Outcome, r.Outcome)
+			}
+		})
+	}
+
+	var records []models.AuditRecord
+
+	resp, err := n.Request("audit.find", []byte(`{"actor": "alice"}`), time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(resp.Data, &records))
+	assert.Contains(t, records[0].Changes, models.FieldChange{Path: "options"})
+
+	// unset ids are still part of the response
+	resp, err = n.Request("audit.find", []byte(`{"target": "uuid-404"}`), time.Second)
+	assert.Nil(t, err)
+	assert.Contains(t, string(resp.Data), `"environment_id":0`)
+	assert.Contains(t, string(resp.Data), `"build_id":""`)
+}
@@ -0,0 +1,89 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ernestio/service-store/handlers"
+	"github.com/ernestio/service-store/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignedRequests(t *testing.T) {
+	_ = os.Setenv("ERNEST_AUTH_KEYS", "api=api-secret,monitor=monitor-secret")
+	_ = os.Setenv("ERNEST_AUTH_SUBJECTS", "environment.del=api")
+	defer os.Unsetenv("ERNEST_AUTH_KEYS")
+	defer os.Unsetenv("ERNEST_AUTH_SUBJECTS")
+
+	setupTestSuite("test_signed_requests")
+
+	db.Unscoped().Delete(models.Environment{}, models.Build{})
+	CreateTestData(db, 20)
+
+	replayed, _ := handlers.Sign("api", "api-secret", "environment.get", "nonce-1", []byte(`{"id": 1}`))
+	tampered, _ := handlers.Sign("api", "api-secret", "environment.get", "nonce-2", []byte(`{"id": 1}`))
+	wrongSubject, _ := handlers.Sign("api", "api-secret", "environment.del", "nonce-3", []byte(`{"id": 2}`))
+	notAllowed, _ := handlers.Sign("monitor", "monitor-secret", "environment.del", "nonce-4", []byte(`{"id": 2}`))
+	unknown, _ := handlers.Sign("intruder", "secret", "environment.get", "nonce-5", []byte(`{"id": 1}`))
+	allowed, _ := handlers.Sign("api", "api-secret", "environment.del", "nonce-6", []byte(`{"id": 2}`))
+
+	var env handlers.Envelope
+	_ = json.Unmarshal(tampered, &env)
+	env.Payload = json.RawMessage(`{"id":3}`)
+	tampered, _ = json.Marshal(env)
+
+	cases := []struct {
+		Name     string
+		Subject  string
+		Data     []byte
+		Expected string
+	}{
+		{"unsigned", "environment.get", []byte(`{"id": 1}`), `"name":"Test1"`},
+		{"signed", "environment.get", replayed, `"name":"Test1"`},
+		{"replayed", "environment.get", replayed, "already received"},
+		{"tampered", "environment.get", tampered, "invalid signature"},
+		{"unknown-identity", "environment.get", unknown, "unknown identity"},
+		{"signed-for-another-subject", "environment.set", wrongSubject, "invalid signature"},
+		{"unsigned-restricted", "environment.del", []byte(`{"id": 2}`), "must be signed"},
+		{"not-allowed", "environment.del", notAllowed, "not allowed"},
+		{"allowed", "environment.del", allowed, "success"},
+		{"unsigned-credentials", "environment.get.credentials", []byte(`{"id": 1}`), "must be signed"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resp, err := n.Request(tc.Subject, tc.Data, time.Second)
+			assert.Nil(t, err)
+			assert.Contains(t, string(resp.Data), tc.Expected)
+		})
+	}
+}
+
+func TestOverlappingSubjectPatterns(t *testing.T) {
+	_ = os.Setenv("ERNEST_AUTH_KEYS", "api=api-secret,monitor=monitor-secret")
+	_ = os.Setenv("ERNEST_AUTH_SUBJECTS", "*.*=monitor;environment.*=api")
+	defer os.Unsetenv("ERNEST_AUTH_KEYS")
+	defer os.Unsetenv("ERNEST_AUTH_SUBJECTS")
+
+	setupTestSuite("test_overlapping_subject_patterns")
+
+	db.Unscoped().Delete(models.Environment{}, models.Build{})
+	CreateTestData(db, 20)
+
+	// the pattern with fewer wildcards applies, however the map is ordered
+	for i := 0; i < 20; i++ {
+		resp, err := n.Request("environment.get", signed("api", "environment.get", []byte(`{"id": 1}`)), time.Second)
+		assert.Nil(t, err)
+		assert.Contains(t, string(resp.Data), `"name":"Test1"`)
+
+		resp, err = n.Request("environment.get", signed("monitor", "environment.get", []byte(`{"id": 1}`)), time.Second)
+		assert.Nil(t, err)
+		assert.Contains(t, string(resp.Data), "not allowed")
+	}
+}
@@ -6,10 +6,13 @@ package main
 
 import (
 	"encoding/json"
+	"os"
 	"testing"
 	"time"
 
+	"github.com/ernestio/service-store/handlers"
 	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
 	"github.com/r3labs/graph"
 	"github.com/stretchr/testify/assert"
 )
@@ -161,6 +164,7 @@ func TestBuildSetComponent(t *testing.T) {
 
 	var b models.Build
 	db.Where("uuid = ?", "uuid-1").First(&b)
+	assert.Nil(t, b.LoadMapping())
 
 	g := graph.New()
 	assert.Nil(t, g.Load(b.Mapping))
@@ -170,6 +174,209 @@ func TestBuildSetComponent(t *testing.T) {
 	assert.Equal(t, "completed", c2.GetState())
 }
 
+func TestBuildComponentQueries(t *testing.T) {
+	setupTestSuite("test_build_component_queries")
+
+	db.Unscoped().Delete(models.Build{}, models.Build{})
+	CreateTestData(db, 20)
+
+	var c map[string]interface{}
+
+	resp, err := n.Request("build.get.component", []byte(`{"id":"uuid-1", "component_id":"network::test-2"}`), time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(resp.Data, &c))
+	assert.Equal(t, "network::test-2", c["_component_id"])
+	assert.Equal(t, "running", c["_state"])
+
+	resp, err = n.Request("build.get.component", []byte(`{"id":"uuid-1", "component_id":"network::test-9"}`), time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"_error":"component network::test-9 not found","_code":"not_found"}`, string(resp.Data))
+
+	var found []map[string]interface{}
+
+	resp, err = n.Request("build.find.components", []byte(`{"id":"uuid-1", "_state":"running", "fields":{"_component_id":"network::test-1"}}`), time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(resp.Data, &found))
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, "network::test-1", found[0]["_component_id"])
+
+	var e models.ComponentEdges
+
+	resp, err = n.Request("build.get.edges", []byte(`{"id":"uuid-1", "component_id":"network::test-1"}`), time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(resp.Data, &e))
+	assert.Equal(t, "network::test-1", e.ID)
+	assert.Equal(t, 0, len(e.Dependencies))
+	assert.Equal(t, 0, len(e.Dependents))
+}
+
+func TestBuildMappingStorage(t *testing.T) {
+	setupTestSuite("test_build_mapping_storage")
+
+	db.Unscoped().Delete(models.Build{}, models.Build{})
+	CreateTestData(db, 20)
+
+	var count int
+	var b models.Build
+
+	// mappings stored with their components are split on the first update
+	_, err := n.Request("build.set.mapping.component", []byte(`{"_component_id":"network::test-5", "service":"uuid-2", "_state": "running"}`), time.Second)
+	assert.Nil(t, err)
+
+	db.Where("uuid = ?", "uuid-2").First(&b)
+	assert.Nil(t, b.Mapping["components"])
+	assert.Equal(t, "uuid-2", b.Mapping["id"])
+
+	db.Model(models.BuildComponent{}).Where("build_id = ?", b.ID).Count(&count)
+	assert.Equal(t, 5, count)
+
+	_, err = n.Request("build.set.mapping", []byte(`{"id":"uuid-3", "mapping": {"id": "uuid-3", "components": [{"_component_id":"network::test-9", "_state": "running"}], "changes": []}}`), time.Second)
+	assert.Nil(t, err)
+
+	db.Where("uuid = ?", "uuid-3").First(&b)
+	assert.Nil(t, b.Mapping["components"])
+
+	resp, err := n.Request("build.get.mapping", []byte(`{"id":"uuid-3"}`), time.Second)
+	assert.Nil(t, err)
+
+	var m map[string]interface{}
+	assert.Nil(t, json.Unmarshal(resp.Data, &m))
+	assert.Equal(t, "uuid-3", m["id"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"_component_id": "network::test-9", "_state": "running"}}, m["components"])
+	assert.Equal(t, []interface{}{}, m["changes"])
+
+	// updating a component only changes its row
+	_, err = n.Request("build.set.mapping.component", []byte(`{"_component_id":"network::test-9", "service":"uuid-3", "_state": "completed"}`), time.Second)
+	assert.Nil(t, err)
+
+	var c models.BuildComponent
+	db.Where("build_id = ? AND component_id = ?", b.ID, "network::test-9").First(&c)
+	assert.Equal(t, "completed", c.Data["_state"])
+	assert.Equal(t, 0, c.Position)
+
+	resp, err = n.Request("build.set.mapping.change", []byte(`{"_component_id":"network::test-10", "service":"uuid-3", "_state": "completed"}`), time.Second)
+	assert.Nil(t, err)
+	assert.Contains(t, string(resp.Data), "change component not found")
+
+	// a mapping without components removes the stored ones
+	_, err = n.Request("build.set", []byte(`{"id":"uuid-3", "mapping": {"id": "uuid-3"}}`), time.Second)
+	assert.Nil(t, err)
+
+	db.Model(models.BuildComponent{}).Where("build_id = ?", b.ID).Count(&count)
+	assert.Equal(t, 0, count)
+
+	// a build whose components can't be stored isn't created
+	resp, err = n.Request("build.set", []byte(`{"environment_id": 4, "type": "apply", "mapping": {"components": [{"_state": "running"}]}}`), time.Second)
+	assert.Nil(t, err)
+	assert.Contains(t, string(resp.Data), "no _component_id")
+
+	db.Model(models.Build{}).Where("environment_id = ?", 4).Count(&count)
+	assert.Equal(t, 1, count)
+}
+
+func TestBuildSetComponents(t *testing.T) {
+	setupTestSuite("test_build_set_components")
+
+	db.Unscoped().Delete(models.Build{}, models.Build{})
+	CreateTestData(db, 20)
+
+	var results []models.BatchResult
+
+	resp, err := n.Request("build.set.mapping.components", []byte(`{"id":"uuid-1", "components": [
+		{"op": "state", "component": {"_component_id":"network::test-1", "_state": "completed"}},
+		{"op": "set", "component": {"_component_id":"network::test-5", "_state": "running"}},
+		{"op": "delete", "component": {"_component_id":"network::test-2"}},
+		{"op": "state", "component": {"_component_id":"network::test-9", "_state": "completed"}},
+		{"op": "move", "component": {"_component_id":"network::test-1"}}
+	]}`), time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(resp.Data, &results))
+
+	assert.Equal(t, []models.BatchResult{
+		{ID: "network::test-1", Op: "state", Status: "ok"},
+		{ID: "network::test-5", Op: "set", Status: "ok"},
+		{ID: "network::test-2", Op: "delete", Status: "ok"},
+		{ID: "network::test-9", Op: "state", Status: "error", Error: "component network::test-9 not found"},
+		{ID: "network::test-1", Op: "move", Status: "error", Error: "unknown batch operation move"},
+	}, results)
+
+	resp, err = n.Request("build.set.mapping.changes", []byte(`{"id":"uuid-1", "changes": [
+		{"op": "set", "component": {"_component_id":"network::test-3", "_state": "completed"}},
+		{"op": "set", "component": {"_component_id":"network::test-8", "_state": "completed"}}
+	]}`), time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(resp.Data, &results))
+	assert.Equal(t, "ok", results[0].Status)
+	assert.Equal(t, "error", results[1].Status)
+
+	var b models.Build
+	db.Where("uuid = ?", "uuid-1").First(&b)
+	assert.Nil(t, b.LoadMapping())
+
+	g := graph.New()
+	assert.Nil(t, g.Load(b.Mapping))
+
+	assert.Equal(t, "completed", g.Component("network::test-1").GetState())
+	assert.Equal(t, "running", g.Component("network::test-5").GetState())
+	assert.Nil(t, g.Component("network::test-2"))
+	assert.Equal(t, "completed", g.Changes[0].GetState())
+	assert.Equal(t, 2, len(g.Changes))
+}
+
+func TestBuildPatch(t *testing.T) {
+	setupTestSuite("test_build_patch")
+
+	db.Unscoped().Delete(models.Build{}, models.Build{})
+	CreateTestData(db, 20)
+
+	cases := []struct {
+		Name     string
+		Subject  string
+		Data     string
+		Expected string
+	}{
+		{"json-patch", "build.patch.mapping", `{"id": "uuid-1", "patch": [
+			{"op": "test", "path": "/components/0/_state", "value": "running"},
+			{"op": "replace", "path": "/components/0/_state", "value": "completed"},
+			{"op": "remove", "path": "/changes/1"}
+		]}`, `"_state":"completed"`},
+		{"test-conflict", "build.patch.mapping", `{"id": "uuid-1", "patch": [
+			{"op": "test", "path": "/components/0/_state", "value": "running"},
+			{"op": "replace", "path": "/components/0/_state", "value": "errored"}
+		]}`, `"_code":"conflict"`},
+		{"merge-patch", "build.patch.mapping", `{"id": "uuid-1", "patch": {"action": "service.update"}}`, `"action":"service.update"`},
+		{"invalid-patch", "build.patch.mapping", `{"id": "uuid-1", "patch": [{"op": "remove", "path": "/missing"}]}`, `"_code":"invalid_patch"`},
+		{"no-patch", "build.patch.mapping", `{"id": "uuid-1"}`, "a patch must be provided"},
+		{"validation", "build.patch.validation", `{"id": "uuid-2", "patch": {"passed": false, "errors": ["too many instances"]}}`, `"passed":false`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resp, err := n.Request(tc.Subject, []byte(tc.Data), time.Second)
+			assert.Nil(t, err)
+			assert.Contains(t, string(resp.Data), tc.Expected)
+		})
+	}
+
+	var b models.Build
+	db.Where("uuid = ?", "uuid-1").First(&b)
+	assert.Equal(t, "service.update", b.Mapping["action"])
+	assert.Nil(t, b.Mapping["components"])
+	assert.Nil(t, b.LoadMapping())
+
+	g := graph.New()
+	assert.Nil(t, g.Load(b.Mapping))
+
+	assert.Equal(t, "completed", g.Component("network::test-1").GetState())
+	assert.Equal(t, "running", g.Component("network::test-2").GetState())
+	assert.Equal(t, 1, len(g.Changes))
+	assert.Equal(t, "network::test-3", g.Changes[0].GetID())
+
+	var count int
+	db.Model(models.BuildComponent{}).Where("build_id = ?", b.ID).Count(&count)
+	assert.Equal(t, 3, count)
+}
+
 func TestBuildSetChange(t *testing.T) {
 	setupTestSuite("test_build_set_change")
 
@@ -182,6 +389,7 @@ func TestBuildSetChange(t *testing.T) {
 
 	var b models.Build
 	db.Where("uuid = ?", "uuid-1").First(&b)
+	assert.Nil(t, b.LoadMapping())
 
 	g := graph.New()
 	assert.Nil(t, g.Load(b.Mapping))
@@ -194,6 +402,149 @@ func TestBuildSetChange(t *testing.T) {
 	}
 }
 
+func TestBuildMaintainChanges(t *testing.T) {
+	setupTestSuite("test_build_maintain_changes")
+
+	db.Unscoped().Delete(models.Build{}, models.Build{})
+	CreateTestData(db, 20)
+
+	cases := []struct {
+		Name     string
+		Subject  string
+		Data     string
+		Expected string
+	}{
+		{"set-missing", "build.set.mapping.change", `{"_component_id":"network::test-5", "service":"uuid-1", "_state": "waiting"}`, "change component not found"},
+		{"upsert", "build.set.mapping.change", `{"_component_id":"network::test-5", "service":"uuid-1", "_state": "waiting", "_upsert": true}`, ""},
+		{"upsert-existing", "build.set.mapping.change", `{"_component_id":"network::test-3", "service":"uuid-1", "_state": "completed", "_upsert": true}`, ""},
+		{"delete", "build.del.mapping.change", `{"_component_id":"network::test-4", "service":"uuid-1"}`, ""},
+		{"move", "build.move.mapping.change", `{"_component_id":"network::test-5", "service":"uuid-1", "position": 0}`, ""},
+		{"move-out-of-range", "build.move.mapping.change", `{"_component_id":"network::test-5", "service":"uuid-1", "position": 2}`, "out of range"},
+		{"move-missing", "build.move.mapping.change", `{"_component_id":"network::test-4", "service":"uuid-1", "position": 0}`, "change component not found"},
+		{"move-without-position", "build.move.mapping.change", `{"_component_id":"network::test-3", "service":"uuid-1"}`, "position must be provided"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resp, err := n.Request(tc.Subject, []byte(tc.Data), time.Second)
+			assert.Nil(t, err)
+			if tc.Expected == "" {
+				assert.NotContains(t, string(resp.Data), "_error")
+			} else {
+				assert.Contains(t, string(resp.Data), tc.Expected)
+			}
+		})
+	}
+
+	var b models.Build
+	db.Where("uuid = ?", "uuid-1").First(&b)
+	assert.Nil(t, b.LoadMapping())
+
+	g := graph.New()
+	assert.Nil(t, g.Load(b.Mapping))
+
+	assert.Equal(t, 2, len(g.Changes))
+	assert.Equal(t, "network::test-5", g.Changes[0].GetID())
+	assert.Equal(t, "network::test-3", g.Changes[1].GetID())
+	assert.Equal(t, "completed", g.Changes[1].GetState())
+
+	var c models.BuildComponent
+	db.Where("build_id = ? AND component_id = ?", b.ID, "network::test-5").First(&c)
+	assert.Nil(t, c.Data["_upsert"])
+}
+
+func TestBuildProgress(t *testing.T) {
+	setupTestSuite("test_build_progress")
+
+	db.Unscoped().Delete(models.Build{}, models.Build{})
+	CreateTestData(db, 20)
+
+	_, err := n.Request("build.set.mapping.change", []byte(`{"_component_id":"network::test-3", "service":"uuid-1", "_state": "completed"}`), time.Second)
+	assert.Nil(t, err)
+
+	var p models.BuildProgress
+
+	resp, err := n.Request("build.get.progress", []byte(`{"id":"uuid-1"}`), time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(resp.Data, &p))
+	assert.Equal(t, map[string]int{"running": 2}, p.Components)
+	assert.Equal(t, map[string]int{"completed": 1, "waiting": 1}, p.Changes)
+	assert.Equal(t, float64(50), p.Percentage)
+
+	events := make(chan []byte, 4)
+	_, _ = n.Subscribe("build.status_changed", func(msg *nats.Msg) {
+		events <- msg.Data
+	})
+
+	next := func(t *testing.T) handlers.BuildStatusChanged {
+		var e handlers.BuildStatusChanged
+
+		select {
+		case data := <-events:
+			assert.Nil(t, json.Unmarshal(data, &e))
+		case <-time.After(time.Second):
+			t.Fatal("no build.status_changed event was published")
+		}
+
+		return e
+	}
+
+	_, err = n.Request("build.set.status", []byte(`{"id":"uuid-1", "status":"errored"}`), time.Second)
+	assert.Nil(t, err)
+
+	e := next(t)
+	assert.Equal(t, "uuid-1", e.ID)
+	assert.Equal(t, "errored", e.Status)
+	assert.Equal(t, 2, e.Progress.Total)
+
+	// accepting a submission completes it and starts a new build
+	db.Exec("UPDATE environments SET status = 'awaiting_approval' WHERE id IN (2, 3)")
+	db.Exec("UPDATE builds SET status = 'awaiting_approval' WHERE uuid IN ('uuid-2', 'uuid-3')")
+
+	_, err = n.Request("build.set", []byte(`{"id":"uuid-30", "environment_id":2, "type":"submission-accepted"}`), time.Second)
+	assert.Nil(t, err)
+
+	e = next(t)
+	assert.Equal(t, "uuid-2", e.ID)
+	assert.Equal(t, "done", e.Status)
+
+	e = next(t)
+	assert.Equal(t, "uuid-30", e.ID)
+	assert.Equal(t, "in_progress", e.Status)
+
+	// expiring a submission rejects it
+	_ = os.Setenv("ERNEST_APPROVAL_EXPIRY", "1h")
+	defer os.Unsetenv("ERNEST_APPROVAL_EXPIRY")
+
+	db.Exec("UPDATE builds SET updated_at = now() - interval '2 hours' WHERE uuid = 'uuid-3'")
+
+	handlers.ExpireBuilds()
+
+	e = next(t)
+	assert.Equal(t, "uuid-3", e.ID)
+	assert.Equal(t, "done", e.Status)
+}
+
+func TestBuildGetMappingDiagram(t *testing.T) {
+	setupTestSuite("test_build_get_mapping_diagram")
+
+	db.Unscoped().Delete(models.Build{}, models.Build{})
+	CreateTestData(db, 20)
+
+	resp, err := n.Request("build.get.mapping", []byte(`{"id":"uuid-1", "format":"dot"}`), time.Second)
+	assert.Nil(t, err)
+	assert.Contains(t, string(resp.Data), `subgraph "cluster_network" {`)
+	assert.Contains(t, string(resp.Data), `"network::test-1" [label="network::test-1", fillcolor="#f7d96b"];`)
+
+	resp, err = n.Request("build.get.mapping", []byte(`{"id":"uuid-1", "format":"mermaid"}`), time.Second)
+	assert.Nil(t, err)
+	assert.Contains(t, string(resp.Data), "flowchart LR")
+
+	resp, err = n.Request("build.get.mapping", []byte(`{"id":"uuid-1", "format":"svg"}`), time.Second)
+	assert.Nil(t, err)
+	assert.Contains(t, string(resp.Data), "unsupported mapping format svg")
+}
+
 func TestBuildSetInProgress(t *testing.T) {
 	setupTestSuite("test_build_set_in_progress")
 
@@ -214,6 +565,87 @@ func TestBuildSetInProgress(t *testing.T) {
 	assert.Contains(t, string(resp.Data), "in progress")
 }
 
+func TestBuildExpiry(t *testing.T) {
+	setupTestSuite("test_build_expiry")
+
+	db.Unscoped().Delete(models.Build{}, models.Build{})
+	CreateTestData(db, 20)
+
+	_ = os.Setenv("ERNEST_APPROVAL_EXPIRY", "1h")
+	_ = os.Setenv("ERNEST_RESOLUTION_EXPIRY", "1h")
+	defer os.Unsetenv("ERNEST_APPROVAL_EXPIRY")
+	defer os.Unsetenv("ERNEST_RESOLUTION_EXPIRY")
+
+	db.Exec("UPDATE environments SET status = 'awaiting_approval' WHERE id IN (1, 2)")
+	db.Exec("UPDATE environments SET status = 'awaiting_resolution' WHERE id = 3")
+	db.Exec("UPDATE builds SET status = 'awaiting_approval' WHERE uuid IN ('uuid-1', 'uuid-2')")
+	db.Exec("UPDATE builds SET status = 'awaiting_resolution', type = 'sync' WHERE uuid = 'uuid-3'")
+	db.Exec("UPDATE builds SET updated_at = now() - interval '2 hours' WHERE uuid IN ('uuid-1', 'uuid-3')")
+
+	handlers.ExpireBuilds()
+
+	e1, err := models.GetEnvironment(map[string]interface{}{"id": 1})
+	assert.Nil(t, err)
+	assert.Equal(t, "done", e1.Status)
+
+	e2, err := models.GetEnvironment(map[string]interface{}{"id": 2})
+	assert.Nil(t, err)
+	assert.Equal(t, "awaiting_approval", e2.Status)
+
+	e3, err := models.GetEnvironment(map[string]interface{}{"id": 3})
+	assert.Nil(t, err)
+	assert.Equal(t, "done", e3.Status)
+
+	b, err := models.GetBuild(map[string]interface{}{"id": "uuid-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "done", b.Status)
+	assert.Contains(t, b.Reason, "expired")
+
+	b, err = models.GetBuild(map[string]interface{}{"id": "uuid-2"})
+	assert.Nil(t, err)
+	assert.Equal(t, "awaiting_approval", b.Status)
+	assert.Empty(t, b.Reason)
+
+	b, err = models.GetBuild(map[string]interface{}{"id": "uuid-3"})
+	assert.Nil(t, err)
+	assert.Equal(t, "done", b.Status)
+	assert.Contains(t, b.Reason, "expired")
+	assert.Equal(t, "ignored", b.Drift["resolution"])
+}
+
+func TestBuildDrift(t *testing.T) {
+	setupTestSuite("test_build_drift")
+
+	db.Unscoped().Delete(models.Build{}, models.Build{})
+	CreateTestData(db, 20)
+
+	db.Exec("UPDATE builds SET type = 'sync' WHERE uuid = 'uuid-1'")
+	db.Exec("UPDATE environments SET status = 'awaiting_resolution' WHERE id = 1")
+
+	resp, err := n.Request("build.set.drift", []byte(`{"id": "uuid-1", "drift": {"added": [{"_component_id": "network::test-5"}], "changed": [{"_component_id": "network::test-1", "fields": [{"path": "subnet", "from": "10.0.0.0/24", "to": "10.0.1.0/24"}]}]}}`), time.Second)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(resp.Data), "error")
+
+	resp, err = n.Request("build.set.drift", []byte(`{"id": "uuid-2", "drift": {}}`), time.Second)
+	assert.Nil(t, err)
+	assert.Contains(t, string(resp.Data), "only be set on sync builds")
+
+	data, _ := json.Marshal(models.Build{EnvironmentID: uint(1), Type: "sync-ignored"})
+	_, err = n.Request("build.set", data, time.Second)
+	assert.Nil(t, err)
+
+	var d models.Drift
+
+	resp, err = n.Request("build.get.drift", []byte(`{"id": "uuid-1"}`), time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(resp.Data, &d))
+	assert.Len(t, d.Added, 1)
+	assert.Len(t, d.Changed, 1)
+	assert.Equal(t, "subnet", d.Changed[0].Fields[0].Path)
+	assert.Equal(t, "ignored", d.Resolution)
+	assert.NotNil(t, d.ResolvedAt)
+}
+
 func TestBuildSetTransaction(t *testing.T) {
 	t.SkipNow()
 
@@ -233,6 +665,7 @@ func TestBuildSetTransaction(t *testing.T) {
 
 	var b models.Build
 	db.Where("uuid = ?", "uuid-1").First(&b)
+	assert.Nil(t, b.LoadMapping())
 
 	g := graph.New()
 	assert.Nil(t, g.Load(b.Mapping))
@@ -0,0 +1,89 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentialSets(t *testing.T) {
+	_ = os.Setenv("ERNEST_CREDENTIAL_READERS", "workflow-manager")
+	_ = os.Setenv("ERNEST_AUTH_KEYS", "workflow-manager=workflow-manager-secret")
+	defer os.Unsetenv("ERNEST_CREDENTIAL_READERS")
+	defer os.Unsetenv("ERNEST_AUTH_KEYS")
+
+	setupTestSuite("test_credential_sets")
+
+	db.Unscoped().Delete(models.Environment{}, models.Build{})
+	db.Unscoped().Delete(models.CredentialSet{})
+	CreateTestData(db, 20)
+
+	db.Model(&models.Environment{}).Where("id in (?)", []uint{1, 2}).Update("project_id", 1)
+
+	var c models.CredentialSet
+	var e models.Environment
+	var usage []models.CredentialSetUsage
+
+	resp, err := n.Request("credentials.set", []byte(`{"project_id": 1, "name": "shared", "credentials": {"username": "shared", "password": "secret"}}`), time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(resp.Data, &c))
+	assert.NotEqual(t, uint(0), c.ID)
+	assert.Equal(t, "shared", c.Credentials["username"])
+	assert.Equal(t, models.RedactedValue, c.Credentials["password"])
+
+	resp, err = n.Request("credentials.set", []byte(`{"project_id": 1, "name": "shared"}`), time.Second)
+	assert.Nil(t, err)
+	assert.Contains(t, string(resp.Data), "already exists")
+
+	for _, id := range []int{1, 2} {
+		data, _ := json.Marshal(map[string]interface{}{"id": id, "credential_set_id": c.ID})
+		resp, err = n.Request("environment.set", data, time.Second)
+		assert.Nil(t, err)
+		assert.NotContains(t, string(resp.Data), "_error")
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{"id": 3, "credential_set_id": c.ID})
+	resp, err = n.Request("environment.set", data, time.Second)
+	assert.Nil(t, err)
+	assert.Contains(t, string(resp.Data), "another project")
+
+	resp, err = n.Request("environment.get", []byte(`{"id": 1}`), time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(resp.Data, &e))
+	assert.Equal(t, c.ID, e.CredentialSetID)
+	assert.Equal(t, "shared", e.Credentials["username"])
+	assert.Equal(t, models.RedactedValue, e.Credentials["password"])
+
+	resp, err = n.Request("environment.get.credentials", signed("workflow-manager", "environment.get.credentials", []byte(`{"id": 2}`)), time.Second)
+	assert.Nil(t, err)
+	assert.Contains(t, string(resp.Data), `"password":"secret"`)
+
+	data, _ = json.Marshal(map[string]interface{}{"id": c.ID})
+	resp, err = n.Request("credentials.find.usage", data, time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(resp.Data, &usage))
+	assert.Equal(t, 2, len(usage))
+	assert.Equal(t, "Test1", usage[0].Name)
+
+	resp, err = n.Request("credentials.del", data, time.Second)
+	assert.Nil(t, err)
+	assert.Contains(t, string(resp.Data), "is used by")
+
+	for _, id := range []int{1, 2} {
+		data, _ := json.Marshal(map[string]interface{}{"id": id, "credentials": map[string]interface{}{"username": "own"}})
+		_, err = n.Request("environment.set", data, time.Second)
+		assert.Nil(t, err)
+	}
+
+	resp, err = n.Request("credentials.del", data, time.Second)
+	assert.Nil(t, err)
+	assert.Contains(t, string(resp.Data), "success")
+}
@@ -6,10 +6,12 @@ package main
 
 import (
 	"encoding/json"
+	"os"
 	"testing"
 	"time"
 
 	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -134,6 +136,12 @@ func TestEnvironmentDelete(t *testing.T) {
 	db.Unscoped().Delete(models.Environment{}, models.Build{})
 	CreateTestData(db, 20)
 
+	// records the deployed state of the environments
+	for _, id := range []string{"1", "2"} {
+		_, err := n.Request("environment.get.state", []byte(`{"id": `+id+`}`), time.Second)
+		assert.Nil(t, err)
+	}
+
 	for _, tc := range cases {
 		t.Run(tc.Name, func(t *testing.T) {
 			data, _ := json.Marshal(tc.Event)
@@ -143,6 +151,56 @@ func TestEnvironmentDelete(t *testing.T) {
 			assert.Contains(t, string(resp.Data), tc.Expected)
 		})
 	}
+
+	var count int
+	db.Model(&models.DeployedState{}).Where("environment_id in (?)", []uint{1, 2}).Count(&count)
+	assert.Equal(t, 0, count)
+}
+
+func TestEnvironmentDeletePolicies(t *testing.T) {
+	setupTestSuite("test_environment_delete_policies")
+
+	db.Unscoped().Delete(models.Environment{}, models.Build{})
+	CreateTestData(db, 20)
+
+	db.Model(&models.Environment{}).Where("id = ?", 1).Update("project_id", 1)
+	db.Create(&models.Environment{Name: "Test1", ProjectID: 2, Status: "done"})
+
+	var query map[string]interface{}
+	updated := make(chan map[string]interface{}, 2)
+
+	_, _ = n.Subscribe("policy.find", func(msg *nats.Msg) {
+		_ = json.Unmarshal(msg.Data, &query)
+		_ = n.Publish(msg.Reply, []byte(`[
+			{"id": 1, "project_id": 1, "environments": ["Test1", "Test3"]},
+			{"id": 2, "project_id": 2, "environments": ["Test1"]}
+		]`))
+	})
+
+	_, _ = n.Subscribe("policy.set", func(msg *nats.Msg) {
+		var p map[string]interface{}
+		_ = json.Unmarshal(msg.Data, &p)
+		updated <- p
+		_ = n.Publish(msg.Reply, msg.Data)
+	})
+
+	resp, err := n.Request("environment.del", []byte(`{"id": 1}`), time.Second)
+	assert.Nil(t, err)
+	assert.Contains(t, string(resp.Data), "success")
+
+	assert.Equal(t, float64(1), query["project_id"])
+	assert.Equal(t, []interface{}{"Test1"}, query["environments"])
+
+	select {
+	case p := <-updated:
+		assert.Equal(t, float64(1), p["id"])
+		assert.Equal(t, []interface{}{"Test3"}, p["environments"])
+	case <-time.After(time.Second):
+		t.Fatal("policy was not updated")
+	}
+
+	// the policy of the other project's Test1 is kept
+	assert.Len(t, updated, 0)
 }
 
 func TestScheduleSet(t *testing.T) {
@@ -191,3 +249,191 @@ func TestScheduleUnset(t *testing.T) {
 		})
 	}
 }
+
+func TestEnvironmentLock(t *testing.T) {
+	_ = os.Setenv("ERNEST_AUTH_KEYS", "api=api-secret,alice=alice-secret,bob=bob-secret")
+	_ = os.Setenv("ERNEST_AUTH_TRUSTED", "api")
+	defer os.Unsetenv("ERNEST_AUTH_KEYS")
+	defer os.Unsetenv("ERNEST_AUTH_TRUSTED")
+
+	setupTestSuite("test_environment_lock")
+
+	db.Unscoped().Delete(models.Environment{}, models.Build{})
+	CreateTestData(db, 20)
+
+	var e models.Environment
+
+	resp, err := n.Request("environment.lock", []byte(`{"name": "Test1", "owner": "alice"}`), time.Second)
+	assert.Nil(t, err)
+	assert.Contains(t, string(resp.Data), "signed request")
+
+	resp, err = n.Request("environment.lock", signed("alice", "environment.lock", []byte(`{"name": "Test1", "owner": "bob", "reason": "manual fix", "ttl": "10m"}`)), time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(resp.Data, &e))
+	assert.Equal(t, "alice", e.LockedBy)
+	assert.NotNil(t, e.LockedUntil)
+
+	cases := []struct {
+		Name     string
+		Subject  string
+		Event    []byte
+		Expected string
+	}{
+		{"build-by-other", "build.set", signed("bob", "build.set", []byte(`{"environment_id": 1, "type": "apply", "user_name": "bob"}`)), "environment_locked"},
+		{"set-by-other", "environment.set", signed("bob", "environment.set", []byte(`{"id": 1, "name": "Test1", "user_name": "bob"}`)), "environment_locked"},
+		{"set-claiming-owner", "environment.set", signed("bob", "environment.set", []byte(`{"id": 1, "name": "Test1", "user_name": "alice"}`)), "environment_locked"},
+		{"unsigned-claiming-owner", "environment.set", []byte(`{"id": 1, "name": "Test1", "user_name": "alice"}`), "environment_locked"},
+		{"lock-by-other", "environment.lock", signed("bob", "environment.lock", []byte(`{"name": "Test1", "owner": "alice"}`)), "environment_locked"},
+		{"unlock-by-other", "environment.unlock", signed("bob", "environment.unlock", []byte(`{"name": "Test1", "owner": "alice"}`)), "environment_locked"},
+		{"set-by-owner", "environment.set", signed("alice", "environment.set", []byte(`{"id": 1, "name": "Test1"}`)), `"name":"Test1"`},
+		{"build-for-owner", "build.set", signed("api", "build.set", []byte(`{"environment_id": 1, "type": "apply", "user_name": "alice"}`)), "in_progress"},
+		{"force-unlock", "environment.unlock", signed("bob", "environment.unlock", []byte(`{"name": "Test1", "force": true}`)), "success"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resp, err := n.Request(tc.Subject, tc.Event, time.Second)
+			assert.Nil(t, err)
+			assert.Contains(t, string(resp.Data), tc.Expected)
+		})
+	}
+
+	resp, err = n.Request("environment.get", []byte(`{"name": "Test1"}`), time.Second)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(resp.Data), "locked_by")
+}
+
+func TestEnvironmentCredentials(t *testing.T) {
+	_ = os.Setenv("ERNEST_CREDENTIAL_READERS", "workflow-manager")
+	_ = os.Setenv("ERNEST_AUTH_KEYS", "workflow-manager=workflow-manager-secret,monitor=monitor-secret")
+	defer os.Unsetenv("ERNEST_CREDENTIAL_READERS")
+	defer os.Unsetenv("ERNEST_AUTH_KEYS")
+
+	setupTestSuite("test_environment_credentials")
+
+	db.Unscoped().Delete(models.Environment{}, models.Build{})
+	CreateTestData(db, 20)
+
+	var e models.Environment
+
+	resp, err := n.Request("environment.set", []byte(`{"id": 1, "name": "Test1", "credentials": {"username": "user", "password": "secret"}}`), time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(resp.Data, &e))
+	assert.Equal(t, "user", e.Credentials["username"])
+	assert.Equal(t, models.RedactedValue, e.Credentials["password"])
+
+	resp, err = n.Request("environment.get", []byte(`{"id": 1}`), time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(resp.Data, &e))
+	assert.Equal(t, models.RedactedValue, e.Credentials["password"])
+
+	// round tripping the redacted environment keeps the stored secret
+	data, _ := json.Marshal(e)
+	_, err = n.Request("environment.set", data, time.Second)
+	assert.Nil(t, err)
+
+	cases := []struct {
+		Name     string
+		Event    []byte
+		Expected string
+	}{
+		{"allowed", signed("workflow-manager", "environment.get.credentials", []byte(`{"id": 1}`)), `"password":"secret"`},
+		{"not-allowed", signed("monitor", "environment.get.credentials", []byte(`{"id": 1}`)), "not allowed"},
+		{"unsigned", []byte(`{"id": 1}`), "must be signed"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resp, err := n.Request("environment.get.credentials", tc.Event, time.Second)
+			assert.Nil(t, err)
+			assert.Contains(t, string(resp.Data), tc.Expected)
+		})
+	}
+}
+
+func TestEnvironmentNamesPerProject(t *testing.T) {
+	setupTestSuite("test_environment_names_per_project")
+
+	db.Unscoped().Delete(models.Environment{}, models.Build{})
+	CreateTestData(db, 20)
+
+	db.Model(&models.Environment{}).Where("id = ?", 1).Update("project_id", 1)
+
+	resp, err := n.Request("environment.set", []byte(`{"name": "Test1", "project_id": 2}`), time.Second)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(resp.Data), "_error")
+
+	resp, err = n.Request("environment.set", []byte(`{"name": "Test1", "project_id": 2}`), time.Second)
+	assert.Nil(t, err)
+	assert.Contains(t, string(resp.Data), "_error")
+
+	cases := []struct {
+		Name     string
+		Subject  string
+		Data     string
+		Expected string
+	}{
+		{"get-ambiguous", "environment.get", `{"name": "Test1"}`, "ambiguous_name"},
+		{"get-by-project", "environment.get", `{"name": "Test1", "project_id": 2}`, `"project_id":2`},
+		{"get-unique-name", "environment.get", `{"name": "Test2"}`, `"name":"Test2"`},
+		{"lock-ambiguous", "environment.lock", `{"name": "Test1", "owner": "alice"}`, "ambiguous_name"},
+		{"status-ambiguous", "build.set.status", `{"name": "Test1", "status": "done"}`, "ambiguous_name"},
+		{"delete-ambiguous", "environment.del", `{"name": "Test1"}`, "ambiguous_name"},
+		{"delete-by-project", "environment.del", `{"name": "Test1", "project_id": 2}`, "success"},
+		{"get-after-delete", "environment.get", `{"name": "Test1"}`, `"project_id":1`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resp, err := n.Request(tc.Subject, []byte(tc.Data), time.Second)
+			assert.Nil(t, err)
+			assert.Contains(t, string(resp.Data), tc.Expected)
+		})
+	}
+}
+
+func TestEnvironmentGetState(t *testing.T) {
+	setupTestSuite("test_environment_get_state")
+
+	db.Unscoped().Delete(models.Environment{}, models.Build{})
+	db.Delete(models.DeployedState{})
+	CreateTestData(db, 20)
+
+	state := func(t *testing.T, q string) string {
+		resp, err := n.Request("environment.get.state", []byte(q), time.Second)
+		assert.Nil(t, err)
+		return string(resp.Data)
+	}
+
+	status := func(t *testing.T, id, s string) {
+		resp, err := n.Request("build.set.status", []byte(`{"id": "`+id+`", "status": "`+s+`"}`), time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, `{"status": "ok"}`, string(resp.Data))
+	}
+
+	t.Run("latest-successful-build", func(t *testing.T) {
+		assert.Contains(t, state(t, `{"id": 1}`), `"id":"uuid-1"`)
+	})
+
+	t.Run("errored-build", func(t *testing.T) {
+		db.Create(&models.Build{UUID: "uuid-21", EnvironmentID: 1, Type: "apply", Status: "in_progress", Mapping: models.Map{"id": "uuid-21"}})
+		status(t, "uuid-21", "errored")
+		assert.Contains(t, state(t, `{"id": 1}`), `"id":"uuid-1"`)
+	})
+
+	t.Run("successful-sync", func(t *testing.T) {
+		db.Create(&models.Build{UUID: "uuid-22", EnvironmentID: 1, Type: "sync", Status: "in_progress", Mapping: models.Map{"id": "uuid-22"}})
+		status(t, "uuid-22", "done")
+		assert.Contains(t, state(t, `{"name": "Test1"}`), `"id":"uuid-22"`)
+	})
+
+	t.Run("rejected-sync", func(t *testing.T) {
+		assert.Nil(t, models.SetLatestDriftResolution(db, 1, "sync-rejected"))
+		assert.Contains(t, state(t, `{"id": 1}`), `"id":"uuid-1"`)
+	})
+
+	t.Run("no-successful-build", func(t *testing.T) {
+		db.Create(&models.Environment{Name: "Test21", Status: "initializing"})
+		assert.Contains(t, state(t, `{"name": "Test21"}`), `"_code":"not_found"`)
+	})
+}
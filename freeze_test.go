@@ -0,0 +1,61 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFreezeBlocksBuilds(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Build    *models.Build
+		Expected string
+	}{
+		{"apply", &models.Build{EnvironmentID: uint(1), Type: "apply"}, "frozen"},
+		{"exempt-sync", &models.Build{EnvironmentID: uint(1), Type: "sync"}, "syncing"},
+		{"other-environment", &models.Build{EnvironmentID: uint(2), Type: "apply"}, "in_progress"},
+	}
+
+	setupTestSuite("test_freeze_builds")
+
+	db.Unscoped().Delete(models.Environment{}, models.Build{})
+	db.Unscoped().Delete(models.Freeze{})
+	CreateTestData(db, 20)
+
+	start := time.Now().Add(-time.Hour)
+	end := time.Now().Add(time.Hour)
+
+	data, _ := json.Marshal(models.Freeze{Name: "release", EnvironmentID: uint(1), StartsAt: &start, EndsAt: &end, Exempt: models.List{"sync"}})
+	resp, err := n.Request("freeze.set", data, time.Second)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(resp.Data), "error")
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			data, _ := json.Marshal(tc.Build)
+			resp, err := n.Request("build.set", data, time.Second)
+			assert.Nil(t, err)
+			assert.Contains(t, string(resp.Data), tc.Expected)
+		})
+	}
+
+	var fs []map[string]interface{}
+
+	resp, err = n.Request("freeze.find", []byte(`{"environment_id": 1}`), time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(resp.Data, &fs))
+	assert.Len(t, fs, 1)
+	assert.NotNil(t, fs[0]["active"])
+
+	resp, err = n.Request("freeze.find", []byte(`{"environment_id": 1, "horizon": "87600h"}`), time.Second)
+	assert.Nil(t, err)
+	assert.Contains(t, string(resp.Data), "horizon can't be longer than 744h0m0s")
+}
@@ -0,0 +1,188 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// auditTarget : what a mutating request changes, and how to load it
+type auditTarget struct {
+	kind string
+	name string
+	q    map[string]interface{}
+}
+
+// audit : snapshots the target of a mutating request, returning a function
+// that records who made the request, what it changed and its outcome once
+// it has been handled. It's deferred at the start of every mutating handler:
+//
+//	defer audit(msg, &err)()
+func audit(msg *nats.Msg, err *error) func() {
+	var p map[string]interface{}
+
+	_ = json.Unmarshal(msg.Data, &p)
+
+	t := auditTargetOf(msg.Subject, p)
+	before := t.snapshot()
+
+	r := models.AuditRecord{
+		Subject:    msg.Subject,
+		Actor:      Caller(msg),
+		TargetType: t.kind,
+		Target:     t.name,
+	}
+
+	r.Verified = r.Actor != ""
+
+	if !r.Verified {
+		r.Actor = claimedActor(p)
+	}
+
+	return func() {
+		after := t.snapshot()
+
+		r.Change, r.Changes = models.DiffSnapshots(before, after)
+		r.Outcome = "success"
+
+		if err != nil && *err != nil {
+			r.Outcome = "failure"
+			r.Error = (*err).Error()
+		}
+
+		s := after
+		if s == nil {
+			s = before
+		}
+
+		switch t.kind {
+		case models.AuditEnvironment:
+			r.EnvironmentID = toUint(s["id"])
+		case models.AuditBuild:
+			r.BuildID, _ = s["id"].(string)
+			r.EnvironmentID = toUint(s["environment_id"])
+		}
+
+		if r.Target == "" && r.EnvironmentID != 0 {
+			r.Target = fmt.Sprint(r.EnvironmentID)
+		}
+
+		if cerr := r.Create(); cerr != nil {
+			log.Println("[ERROR] : could not record audit of " + msg.Subject + ": " + cerr.Error())
+		}
+	}
+}
+
+// auditTargetOf : works out the target of a request from its subject and
+// payload
+func auditTargetOf(subject string, p map[string]interface{}) auditTarget {
+	kind := strings.Split(subject, ".")[0]
+
+	switch kind {
+	case "environment":
+		t := auditTarget{kind: models.AuditEnvironment}
+		switch {
+		case strings.HasSuffix(subject, ".schedule"):
+			// the id of schedule requests is the schedule's
+			t.set("name", p["name"])
+			t.set("project_id", p["project_id"])
+		case !t.set("id", p["id"]):
+			t.set("name", p["name"])
+			t.set("project_id", p["project_id"])
+		}
+		return t
+	case "build":
+		t := auditTarget{kind: models.AuditBuild}
+		if !t.set("uuid", p["id"]) && !t.set("uuid", p["service"]) {
+			// build.set.status can target the latest build of an environment
+			t = auditTarget{kind: models.AuditEnvironment}
+			t.set("name", p["name"])
+			t.set("project_id", p["project_id"])
+		}
+		return t
+	case "freeze":
+		t := auditTarget{kind: models.AuditFreeze}
+		t.set("id", p["id"])
+		return t
+	case "project":
+		t := auditTarget{kind: models.AuditProject}
+		t.set("project_id", p["project_id"])
+		return t
+	case "credentials":
+		t := auditTarget{kind: models.AuditCredentialSet}
+		if !t.set("id", p["id"]) && t.set("project_id", p["project_id"]) {
+			t.set("name", p["name"])
+		}
+		return t
+	}
+
+	return auditTarget{kind: kind}
+}
+
+// set : adds a field to the target's query if it has a value
+func (t *auditTarget) set(field string, v interface{}) bool {
+	switch x := v.(type) {
+	case nil:
+		return false
+	case string:
+		if x == "" {
+			return false
+		}
+	case float64:
+		if x == 0 {
+			return false
+		}
+	}
+
+	if t.q == nil {
+		t.q = make(map[string]interface{})
+	}
+
+	t.q[field] = v
+
+	if t.name == "" {
+		t.name = fmt.Sprint(v)
+	}
+
+	return true
+}
+
+func (t auditTarget) snapshot() map[string]interface{} {
+	q := make(map[string]interface{})
+	for k, v := range t.q {
+		q[k] = v
+	}
+
+	s, err := models.AuditSnapshot(t.kind, q)
+	if err != nil {
+		log.Println("[ERROR] : could not snapshot audit target: " + err.Error())
+	}
+
+	return s
+}
+
+// claimedActor : the user a request says it was made by. It can't be
+// verified unless the request is signed
+func claimedActor(p map[string]interface{}) string {
+	for _, field := range []string{"user_name", "username", "owner"} {
+		if s, ok := p[field].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+func toUint(v interface{}) uint {
+	if f, ok := v.(float64); ok && f > 0 {
+		return uint(f)
+	}
+	return 0
+}
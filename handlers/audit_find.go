@@ -0,0 +1,50 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// AuditFind : finds audit records by actor, target or any other of their
+// fields, created between from and to
+func AuditFind(msg *nats.Msg) {
+	var err error
+	var q map[string]interface{}
+	var req struct {
+		From  *time.Time `json:"from"`
+		To    *time.Time `json:"to"`
+		Limit int        `json:"limit"`
+	}
+	var records []models.AuditRecord
+	var data []byte
+
+	defer response(msg.Reply, &data, &err)
+
+	if len(msg.Data) < 1 {
+		msg.Data = []byte(`{}`)
+	}
+
+	err = json.Unmarshal(msg.Data, &q)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(msg.Data, &req)
+	if err != nil {
+		return
+	}
+
+	records, err = models.FindAuditRecords(q, req.From, req.To, req.Limit)
+	if err != nil {
+		return
+	}
+
+	data, err = json.Marshal(records)
+}
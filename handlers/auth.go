@@ -0,0 +1,492 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+	"github.com/r3labs/pattern"
+)
+
+// DefaultAuthMaxAge : how old a signed request can be
+const DefaultAuthMaxAge = time.Minute * 5
+
+// Envelope : a request signed by its caller. The signature is the hex
+// encoded HMAC-SHA256 of the subject, timestamp, nonce and payload joined by
+// new lines, keyed with the caller's secret
+type Envelope struct {
+	Identity  string          `json:"_identity"`
+	Timestamp int64           `json:"_timestamp"`
+	Nonce     string          `json:"_nonce"`
+	Signature string          `json:"_signature"`
+	Payload   json.RawMessage `json:"_payload"`
+}
+
+// UnauthorizedError : a request that could not be authenticated or whose
+// caller is not allowed on the subject
+type UnauthorizedError struct {
+	Reason string
+}
+
+func (e *UnauthorizedError) Error() string {
+	return "unauthorized: " + e.Reason
+}
+
+// Code ...
+func (e *UnauthorizedError) Code() string {
+	return "unauthorized"
+}
+
+// Authenticator : verifies signed requests before they reach the handlers
+type Authenticator struct {
+	Keys          map[string]string
+	Subjects      map[string]models.List
+	Scopes        map[string]*models.Scope
+	Trusted       models.List
+	Required      bool
+	ScopeRequired bool
+	MaxAge        time.Duration
+	mu            sync.Mutex
+	seen          map[string]time.Time
+	pruned        time.Time
+}
+
+// restrictedSubjects : subjects that are restricted to the listed callers
+// unless ERNEST_AUTH_SUBJECTS lists them itself. Decrypted credentials can
+// only be read by the services in ERNEST_CREDENTIAL_READERS
+func restrictedSubjects() map[string]models.List {
+	return map[string]models.List{
+		"environment.get.credentials": models.CredentialReaders(),
+	}
+}
+
+// callers : the verified identity of the requests being handled
+var callers sync.Map
+
+// delegates : the requests being handled whose callers are trusted to act
+// for users
+var delegates sync.Map
+
+// Caller : returns the verified identity of the caller of a request, if it
+// was signed. Access decisions must be made on it rather than on any
+// identity the payload claims
+func Caller(msg *nats.Msg) string {
+	identity, _ := callers.Load(msg)
+	s, _ := identity.(string)
+	return s
+}
+
+// actor : returns who a request acts as, such as the owner of the locks it
+// takes. It's the verified caller, or the user a trusted caller acts for.
+// Unsigned requests act as no one
+func actor(msg *nats.Msg, user string) string {
+	if _, ok := delegates.Load(msg); ok && user != "" {
+		return user
+	}
+	return Caller(msg)
+}
+
+// LoadAuthenticator : loads the caller secrets from ERNEST_AUTH_KEYS as
+// identity=secret pairs separated by commas, and the callers allowed on each
+// subject from ERNEST_AUTH_SUBJECTS as subject=identity,... entries separated
+// by semicolons. The projects of each caller are loaded from
+// ERNEST_AUTH_SCOPES as identity=project_id,... entries separated by
+// semicolons, and the callers that aren't restricted to any project from the
+// comma separated ERNEST_AUTH_TRUSTED. ERNEST_AUTH_REQUIRED requires every
+// request to be signed, and ERNEST_SCOPE_REQUIRED, which is implied by
+// ERNEST_AUTH_SCOPES, refuses callers that are neither scoped nor trusted
+func LoadAuthenticator() (*Authenticator, error) {
+	a := Authenticator{
+		Keys:          make(map[string]string),
+		Subjects:      make(map[string]models.List),
+		Scopes:        make(map[string]*models.Scope),
+		Required:      os.Getenv("ERNEST_AUTH_REQUIRED") == "true",
+		ScopeRequired: os.Getenv("ERNEST_SCOPE_REQUIRED") == "true",
+		MaxAge:        DefaultAuthMaxAge,
+	}
+
+	if s := os.Getenv("ERNEST_AUTH_MAX_AGE"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, errors.New("ERNEST_AUTH_MAX_AGE must be a duration")
+		}
+		a.MaxAge = d
+	}
+
+	for _, pair := range strings.Split(os.Getenv("ERNEST_AUTH_KEYS"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || kv[1] == "" {
+			return nil, errors.New("ERNEST_AUTH_KEYS must be a list of identity=secret pairs")
+		}
+
+		a.Keys[strings.TrimSpace(kv[0])] = kv[1]
+	}
+
+	for _, entry := range strings.Split(os.Getenv("ERNEST_AUTH_SUBJECTS"), ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, errors.New("ERNEST_AUTH_SUBJECTS must be a list of subject=identity,... entries")
+		}
+
+		var identities models.List
+		for _, id := range strings.Split(kv[1], ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				identities = append(identities, id)
+			}
+		}
+
+		a.Subjects[strings.TrimSpace(kv[0])] = identities
+	}
+
+	for _, entry := range strings.Split(os.Getenv("ERNEST_AUTH_SCOPES"), ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, errors.New("ERNEST_AUTH_SCOPES must be a list of identity=project_id,... entries")
+		}
+
+		s := models.Scope{ProjectIDs: []uint{}}
+		for _, id := range strings.Split(kv[1], ",") {
+			if id = strings.TrimSpace(id); id == "" {
+				continue
+			}
+
+			pid, err := strconv.ParseUint(id, 10, 32)
+			if err != nil {
+				return nil, errors.New("ERNEST_AUTH_SCOPES project ids must be numbers")
+			}
+
+			s.ProjectIDs = append(s.ProjectIDs, uint(pid))
+		}
+
+		a.Scopes[strings.TrimSpace(kv[0])] = &s
+		a.ScopeRequired = true
+	}
+
+	for _, id := range strings.Split(os.Getenv("ERNEST_AUTH_TRUSTED"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			a.Trusted = append(a.Trusted, id)
+		}
+	}
+
+	for s, identities := range restrictedSubjects() {
+		if _, ok := a.Subjects[s]; !ok {
+			a.Subjects[s] = identities
+		}
+	}
+
+	return &a, nil
+}
+
+// Handle : wraps a handler so it only receives authenticated requests, with
+// the envelope of signed requests removed
+func (a *Authenticator) Handle(h nats.MsgHandler) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		m, identity, err := a.Verify(msg)
+		if err == nil {
+			m, err = a.scope(m, identity)
+		}
+
+		if err != nil {
+			log.Println("[AUTH] : " + msg.Subject + " refused: " + err.Error())
+			response(msg.Reply, nil, &err)
+			return
+		}
+
+		if identity != "" {
+			callers.Store(m, identity)
+			defer callers.Delete(m)
+		}
+
+		if identity != "" && a.Trusted.Contains(identity) {
+			delegates.Store(m, true)
+			defer delegates.Delete(m)
+		}
+
+		h(m)
+	}
+}
+
+// Verify : checks the signature and age of a signed request and that its
+// caller is allowed on the subject, returning the unwrapped request and the
+// caller's identity. Unsigned requests are passed through unless signing is
+// required for the subject
+func (a *Authenticator) Verify(msg *nats.Msg) (*nats.Msg, string, error) {
+	allowed, restricted := a.allowed(msg.Subject)
+
+	env, signed := envelope(msg.Data)
+	if !signed {
+		if a.Required || restricted {
+			return nil, "", &UnauthorizedError{"request must be signed"}
+		}
+		return msg, "", nil
+	}
+
+	key, ok := a.Keys[env.Identity]
+	if !ok || env.Identity == "" {
+		return nil, "", &UnauthorizedError{"unknown identity " + env.Identity}
+	}
+
+	if !hmac.Equal([]byte(env.Signature), []byte(Signature(key, msg.Subject, env.Timestamp, env.Nonce, env.Payload))) {
+		return nil, "", &UnauthorizedError{"invalid signature"}
+	}
+
+	if restricted && !allowed.Contains(env.Identity) {
+		return nil, "", &UnauthorizedError{env.Identity + " is not allowed on " + msg.Subject}
+	}
+
+	err := a.checkReplay(env)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &nats.Msg{
+		Subject: msg.Subject,
+		Reply:   msg.Reply,
+		Data:    []byte(env.Payload),
+		Sub:     msg.Sub,
+	}, env.Identity, nil
+}
+
+// eventSubjects : the build events published by the workflow, which aren't
+// requests made on behalf of a project so are never scoped
+var eventSubjects = []string{"build.*.done", "build.*.error"}
+
+// isEvent : checks if a subject is one of the build events
+func isEvent(subject string) bool {
+	for _, e := range eventSubjects {
+		if pattern.Match(subject, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// scope : sets the scope of a request to the one of its verified caller.
+// Trusted callers aren't restricted, but can pass on the scope of the user
+// they act for. The scope any other request carries is removed, and when
+// scoping is required requests of callers without one are refused, whether
+// they expect a reply or not. Build events are not scoped
+func (a *Authenticator) scope(msg *nats.Msg, identity string) (*nats.Msg, error) {
+	var s *models.Scope
+
+	switch {
+	case identity != "" && a.Trusted.Contains(identity):
+		return msg, nil
+	case identity != "" && a.Scopes[identity] != nil:
+		s = a.Scopes[identity]
+	case a.ScopeRequired && !isEvent(msg.Subject):
+		if identity == "" {
+			return nil, &UnauthorizedError{"request must be signed"}
+		}
+		return nil, &UnauthorizedError{identity + " has no scope"}
+	}
+
+	data, err := withScope(msg.Data, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &nats.Msg{
+		Subject: msg.Subject,
+		Reply:   msg.Reply,
+		Data:    data,
+		Sub:     msg.Sub,
+	}, nil
+}
+
+// withScope : replaces the scope of a request's payload. Payloads that
+// aren't objects can't carry a scope, so are only accepted unscoped
+func withScope(data []byte, s *models.Scope) ([]byte, error) {
+	var req map[string]json.RawMessage
+
+	if len(bytes.TrimSpace(data)) < 1 {
+		data = []byte(`{}`)
+	}
+
+	err := json.Unmarshal(data, &req)
+	if err != nil && s == nil {
+		return data, nil
+	}
+
+	if err != nil {
+		return nil, &UnauthorizedError{"request must be an object"}
+	}
+
+	if req == nil {
+		req = make(map[string]json.RawMessage)
+	}
+
+	if _, ok := req[models.ScopeField]; !ok && s == nil {
+		return data, nil
+	}
+
+	delete(req, models.ScopeField)
+
+	if s != nil {
+		req[models.ScopeField], err = json.Marshal(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(req)
+}
+
+// allowed : returns the identities allowed on the subject, if it's restricted.
+// Subjects listed by name take precedence over patterns, and of the patterns
+// matching the subject the most specific one applies
+func (a *Authenticator) allowed(subject string) (models.List, bool) {
+	if identities, ok := a.Subjects[subject]; ok {
+		return identities, true
+	}
+
+	var match string
+	var found bool
+
+	for s := range a.Subjects {
+		if pattern.Match(subject, s) && (!found || moreSpecific(s, match)) {
+			match = s
+			found = true
+		}
+	}
+
+	if !found {
+		return nil, false
+	}
+
+	return a.Subjects[match], true
+}
+
+// moreSpecific : orders subject patterns by their number of wildcards, then
+// by their length, then alphabetically
+func moreSpecific(p, q string) bool {
+	pw, qw := strings.Count(p, "*"), strings.Count(q, "*")
+	if pw != qw {
+		return pw < qw
+	}
+
+	if len(p) != len(q) {
+		return len(p) > len(q)
+	}
+
+	return p < q
+}
+
+// checkReplay : refuses requests outside of the max age and nonces that were
+// already used within it
+func (a *Authenticator) checkReplay(env *Envelope) error {
+	now := time.Now()
+	ts := time.Unix(env.Timestamp, 0)
+
+	if ts.Before(now.Add(-a.MaxAge)) || ts.After(now.Add(a.MaxAge)) {
+		return &UnauthorizedError{"request timestamp is outside of the allowed window"}
+	}
+
+	if env.Nonce == "" {
+		return &UnauthorizedError{"request must have a nonce"}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.seen == nil {
+		a.seen = make(map[string]time.Time)
+	}
+
+	if now.Sub(a.pruned) > a.MaxAge {
+		for n, t := range a.seen {
+			if t.Before(now.Add(-a.MaxAge)) {
+				delete(a.seen, n)
+			}
+		}
+		a.pruned = now
+	}
+
+	id := env.Identity + ":" + env.Nonce
+
+	if _, ok := a.seen[id]; ok {
+		return &UnauthorizedError{"request was already received"}
+	}
+
+	a.seen[id] = ts
+
+	return nil
+}
+
+// envelope : returns the envelope of a signed request
+func envelope(data []byte) (*Envelope, bool) {
+	var env Envelope
+
+	if len(data) < 1 || data[0] != '{' {
+		return nil, false
+	}
+
+	err := json.Unmarshal(data, &env)
+	if err != nil || env.Signature == "" {
+		return nil, false
+	}
+
+	return &env, true
+}
+
+// Signature : signs a request with the caller's secret
+func Signature(key, subject string, timestamp int64, nonce string, payload []byte) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(subject + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + nonce + "\n"))
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Sign : wraps a request in an envelope signed with the caller's secret
+func Sign(identity, key, subject, nonce string, payload []byte) ([]byte, error) {
+	var compact bytes.Buffer
+
+	ts := time.Now().Unix()
+
+	if len(payload) < 1 {
+		payload = []byte(`{}`)
+	}
+
+	// the payload is sent compacted, so it has to be signed compacted
+	err := json.Compact(&compact, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	payload = compact.Bytes()
+
+	return json.Marshal(Envelope{
+		Identity:  identity,
+		Timestamp: ts,
+		Nonce:     nonce,
+		Signature: Signature(key, subject, ts, nonce, payload),
+		Payload:   json.RawMessage(payload),
+	})
+}
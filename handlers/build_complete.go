@@ -17,10 +17,13 @@ import (
 func BuildComplete(msg *nats.Msg) {
 	var m Message
 	var b models.Build
+	var err error
 
 	parts := strings.Split(msg.Subject, ".")
 
-	err := json.Unmarshal(msg.Data, &m)
+	defer audit(msg, &err)()
+
+	err = json.Unmarshal(msg.Data, &m)
 	if err != nil {
 		log.Println("could not load completion event: " + err.Error())
 	}
@@ -35,6 +38,7 @@ func BuildComplete(msg *nats.Msg) {
 		e, err := models.GetEnvironment(map[string]interface{}{"id": b.EnvironmentID})
 		if err != nil {
 			log.Println("could not get service from service complete message: " + err.Error())
+			return
 		}
 
 		err = e.Delete()
@@ -42,7 +46,7 @@ func BuildComplete(msg *nats.Msg) {
 			log.Println("could not get delete the service: " + err.Error())
 		}
 
-		DeleteRoles(e.Name)
-		DetatchPolicies(e.Name)
+		DeleteRoles(e)
+		DetatchPolicies(e)
 	}
 }
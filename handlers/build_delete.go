@@ -18,6 +18,7 @@ func BuildDelete(msg *nats.Msg) {
 	var data []byte
 
 	defer response(msg.Reply, &data, &err)
+	defer audit(msg, &err)()
 
 	err = json.Unmarshal(msg.Data, &build)
 	if err != nil {
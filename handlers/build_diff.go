@@ -0,0 +1,66 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/jinzhu/gorm"
+	"github.com/nats-io/go-nats"
+)
+
+// BuildDiff : compares the mapping of a build with the one of another build,
+// or of the environment's previous build if none is given
+func BuildDiff(msg *nats.Msg) {
+	var err error
+	var req struct {
+		From   string        `json:"from"`
+		To     string        `json:"to"`
+		Ignore []string      `json:"ignore"`
+		Scope  *models.Scope `json:"_scope"`
+	}
+	var from, to *models.Build
+	var d *models.BuildDiff
+	var data []byte
+
+	defer response(msg.Reply, &data, &err)
+
+	err = json.Unmarshal(msg.Data, &req)
+	if err != nil {
+		return
+	}
+
+	if req.To == "" {
+		err = errors.New("a valid to build id must be provided")
+		return
+	}
+
+	to, err = models.GetBuild(map[string]interface{}{"uuid": req.To, models.ScopeField: req.Scope})
+	if err != nil {
+		return
+	}
+
+	if req.From != "" {
+		from, err = models.GetBuild(map[string]interface{}{"uuid": req.From, models.ScopeField: req.Scope})
+	} else {
+		from, err = to.Previous()
+		if err == gorm.ErrRecordNotFound {
+			from, err = nil, nil
+		}
+	}
+
+	if err != nil {
+		return
+	}
+
+	d, err = models.DiffBuilds(from, to, req.Ignore)
+	if err != nil {
+		return
+	}
+
+	data, err = json.Marshal(d)
+}
@@ -16,8 +16,11 @@ import (
 func BuildError(msg *nats.Msg) {
 	var m Message
 	var b models.Build
+	var err error
 
-	err := json.Unmarshal(msg.Data, &m)
+	defer audit(msg, &err)()
+
+	err = json.Unmarshal(msg.Data, &m)
 	if err != nil {
 		log.Println("could not handle service complete message: " + err.Error())
 	}
@@ -0,0 +1,32 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/ernestio/service-store/models"
+)
+
+// ExpireBuilds : rejects or ignores builds that have been waiting too long
+// for a decision, publishing a build.expired event for each of them
+func ExpireBuilds() {
+	expired, err := models.ExpirePending(models.ExpiryWindowsFromEnv())
+	if err != nil {
+		log.Println("[ERROR] : " + err.Error())
+		return
+	}
+
+	for _, e := range expired {
+		data, err := json.Marshal(e)
+		if err != nil {
+			log.Println("[ERROR] : " + err.Error())
+			continue
+		}
+
+		pub("build.expired", data)
+	}
+}
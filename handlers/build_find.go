@@ -37,6 +37,7 @@ func BuildFind(msg *nats.Msg) {
 	for i := range builds {
 		builds[i].Mapping = nil
 		builds[i].Definition = ""
+		builds[i].Drift = nil
 	}
 
 	data, err = json.Marshal(builds)
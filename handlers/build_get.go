@@ -32,6 +32,7 @@ func BuildGet(msg *nats.Msg) {
 
 	build.Mapping = nil
 	build.Definition = ""
+	build.Drift = nil
 
 	data, err = json.Marshal(build)
 }
@@ -0,0 +1,69 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// BuildPatchMapping : applies a JSON patch or merge patch to a build's mapping
+func BuildPatchMapping(msg *nats.Msg) {
+	patchBuild(msg, func(b *models.Build, id string, p *models.Patch) (interface{}, error) {
+		err := b.PatchMapping(id, p)
+		return b.Mapping, err
+	})
+}
+
+// BuildPatchValidation : applies a JSON patch or merge patch to a build's
+// validation
+func BuildPatchValidation(msg *nats.Msg) {
+	patchBuild(msg, func(b *models.Build, id string, p *models.Patch) (interface{}, error) {
+		err := b.PatchValidation(id, p)
+		return b.Validation, err
+	})
+}
+
+func patchBuild(msg *nats.Msg, patch func(b *models.Build, id string, p *models.Patch) (interface{}, error)) {
+	var err error
+	var data []byte
+	var req struct {
+		ID    string        `json:"id"`
+		Patch models.Patch  `json:"patch"`
+		Scope *models.Scope `json:"_scope"`
+	}
+	var b models.Build
+	var patched interface{}
+
+	defer response(msg.Reply, &data, &err)
+	defer audit(msg, &err)()
+
+	err = json.Unmarshal(msg.Data, &req)
+	if err != nil {
+		return
+	}
+
+	if req.ID == "" {
+		err = errors.New("a valid build id must be provided")
+		return
+	}
+
+	if req.Patch.Empty() {
+		err = errors.New("a patch must be provided")
+		return
+	}
+
+	b.Scope = req.Scope
+
+	patched, err = patch(&b, req.ID, &req.Patch)
+	if err != nil {
+		return
+	}
+
+	data, err = json.Marshal(patched)
+}
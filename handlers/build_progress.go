@@ -0,0 +1,76 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// BuildStatusChanged : the event published when the status of a build changes
+type BuildStatusChanged struct {
+	ID            string                `json:"id"`
+	EnvironmentID uint                  `json:"environment_id"`
+	Status        string                `json:"status"`
+	Progress      *models.BuildProgress `json:"progress,omitempty"`
+}
+
+// BuildGetProgress : gets the counts of a build's components and changes by
+// their state
+func BuildGetProgress(msg *nats.Msg) {
+	var err error
+	var data []byte
+	var m Message
+	var b *models.Build
+	var p *models.BuildProgress
+
+	defer response(msg.Reply, &data, &err)
+
+	err = json.Unmarshal(msg.Data, &m)
+	if err != nil {
+		return
+	}
+
+	b, err = models.GetBuild(m.build())
+	if err != nil {
+		return
+	}
+
+	p, err = b.Progress()
+	if err != nil {
+		return
+	}
+
+	data, err = json.Marshal(p)
+}
+
+// PublishStatusChanged : publishes a build.status_changed event with the
+// status and progress of the build. It's called by the models whenever the
+// status of a build changes
+func PublishStatusChanged(b *models.Build) {
+	e := BuildStatusChanged{
+		ID:            b.UUID,
+		EnvironmentID: b.EnvironmentID,
+		Status:        b.Status,
+	}
+
+	p, err := b.Progress()
+	if err != nil {
+		log.Println("[ERROR] : could not get progress of build " + b.UUID + ": " + err.Error())
+	}
+
+	e.Progress = p
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Println("[ERROR] : " + err.Error())
+		return
+	}
+
+	pub("build.status_changed", data)
+}
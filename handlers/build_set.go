@@ -18,12 +18,15 @@ func BuildSet(msg *nats.Msg) {
 	var data []byte
 
 	defer response(msg.Reply, &data, &err)
+	defer audit(msg, &err)()
 
 	err = json.Unmarshal(msg.Data, &build)
 	if err != nil {
 		return
 	}
 
+	build.Actor = actor(msg, build.Username)
+
 	_, err = models.GetBuild(map[string]interface{}{"uuid": build.UUID})
 	if err != nil {
 		err = build.Create()
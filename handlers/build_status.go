@@ -19,12 +19,15 @@ func SetBuildStatus(msg *nats.Msg) {
 	var b models.Build
 	var cb *models.Build
 	var bs struct {
-		ID     string `json:"id"`
-		Name   string `json:"name"`
-		Status string `json:"status"`
+		ID        string        `json:"id"`
+		ProjectID uint          `json:"project_id"`
+		Name      string        `json:"name"`
+		Status    string        `json:"status"`
+		Scope     *models.Scope `json:"_scope"`
 	}
 
 	defer response(msg.Reply, &data, &err)
+	defer audit(msg, &err)()
 
 	err = json.Unmarshal(msg.Data, &bs)
 	if err != nil {
@@ -37,7 +40,7 @@ func SetBuildStatus(msg *nats.Msg) {
 	}
 
 	if bs.ID == "" && bs.Name != "" {
-		e, err = models.GetEnvironment(map[string]interface{}{"name": bs.Name})
+		e, err = models.GetEnvironment(byName(bs.Name, bs.ProjectID, bs.Scope))
 		if err != nil {
 			return
 		}
@@ -50,6 +53,8 @@ func SetBuildStatus(msg *nats.Msg) {
 		bs.ID = cb.UUID
 	}
 
+	b.Scope = bs.Scope
+
 	err = b.SetStatus(bs.ID, bs.Status)
 	if err != nil {
 		return
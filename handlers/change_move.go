@@ -0,0 +1,44 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+	"github.com/r3labs/graph"
+)
+
+// BuildMoveChange : moves a mapping change to another position
+func BuildMoveChange(msg *nats.Msg) {
+	var err error
+	var b models.Build
+	var req struct {
+		Service     string        `json:"service"`
+		ComponentID string        `json:"_component_id"`
+		Position    *int          `json:"position"`
+		Scope       *models.Scope `json:"_scope"`
+	}
+
+	defer response(msg.Reply, nil, &err)
+	defer audit(msg, &err)()
+
+	err = json.Unmarshal(msg.Data, &req)
+	if err != nil {
+		return
+	}
+
+	// a missing position would otherwise move the change to the front
+	if req.Position == nil {
+		err = errors.New("a position must be provided")
+		return
+	}
+
+	b.Scope = req.Scope
+
+	err = b.MoveChange(&graph.GenericComponent{"service": req.Service, "_component_id": req.ComponentID}, *req.Position)
+}
@@ -0,0 +1,62 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// BuildSetComponents : applies a batch of operations on mapping components
+func BuildSetComponents(msg *nats.Msg) {
+	setMappingBatch(msg, models.ComponentKind)
+}
+
+// BuildSetChanges : applies a batch of operations on mapping changes
+func BuildSetChanges(msg *nats.Msg) {
+	setMappingBatch(msg, models.ChangeKind)
+}
+
+func setMappingBatch(msg *nats.Msg, kind string) {
+	var err error
+	var data []byte
+	var req struct {
+		Message
+		Components []models.BatchItem `json:"components"`
+		Changes    []models.BatchItem `json:"changes"`
+	}
+	var b models.Build
+	var results []models.BatchResult
+
+	defer response(msg.Reply, &data, &err)
+	defer audit(msg, &err)()
+
+	err = json.Unmarshal(msg.Data, &req)
+	if err != nil {
+		return
+	}
+
+	if req.ID == "" {
+		err = errors.New("a valid build id must be provided")
+		return
+	}
+
+	items := req.Components
+	if kind == models.ChangeKind {
+		items = req.Changes
+	}
+
+	b.Scope = req.Scope
+
+	results, err = b.ApplyBatch(req.ID, kind, items)
+	if err != nil {
+		return
+	}
+
+	data, err = json.Marshal(results)
+}
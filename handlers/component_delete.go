@@ -19,11 +19,14 @@ func BuildDeleteComponent(msg *nats.Msg) {
 	var c graph.GenericComponent
 
 	defer response(msg.Reply, nil, &err)
+	defer audit(msg, &err)()
 
 	err = json.Unmarshal(msg.Data, &c)
 	if err != nil {
 		return
 	}
 
+	b.Scope = models.ScopeOf(c)
+
 	err = b.DeleteComponent(&c)
 }
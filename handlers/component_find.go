@@ -0,0 +1,49 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// BuildFindComponents : finds the components of a build's mapping by their
+// type, provider, state or fields
+func BuildFindComponents(msg *nats.Msg) {
+	var err error
+	var data []byte
+	var m struct {
+		Message
+		models.ComponentFilter
+	}
+	var b *models.Build
+	var c []map[string]interface{}
+
+	defer response(msg.Reply, &data, &err)
+
+	err = json.Unmarshal(msg.Data, &m)
+	if err != nil {
+		return
+	}
+
+	b, err = models.GetBuild(m.build())
+	if err != nil {
+		return
+	}
+
+	err = b.LoadMapping()
+	if err != nil {
+		return
+	}
+
+	c, err = b.Mapping.FindComponents(m.ComponentFilter)
+	if err != nil {
+		return
+	}
+
+	data, err = json.Marshal(c)
+}
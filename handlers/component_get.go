@@ -0,0 +1,48 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// BuildGetComponent : gets a component of a build's mapping
+func BuildGetComponent(msg *nats.Msg) {
+	var err error
+	var data []byte
+	var m struct {
+		Message
+		ComponentID string `json:"component_id"`
+	}
+	var b *models.Build
+	var c map[string]interface{}
+
+	defer response(msg.Reply, &data, &err)
+
+	err = json.Unmarshal(msg.Data, &m)
+	if err != nil {
+		return
+	}
+
+	b, err = models.GetBuild(m.build())
+	if err != nil {
+		return
+	}
+
+	err = b.LoadMapping()
+	if err != nil {
+		return
+	}
+
+	c, err = b.Mapping.Component(m.ComponentID)
+	if err != nil {
+		return
+	}
+
+	data, err = json.Marshal(c)
+}
@@ -0,0 +1,42 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// CredentialSetFind : finds credential sets with their secrets redacted
+func CredentialSetFind(msg *nats.Msg) {
+	var err error
+	var q map[string]interface{}
+	var sets []models.CredentialSet
+	var data []byte
+
+	defer response(msg.Reply, &data, &err)
+
+	if len(msg.Data) < 1 {
+		msg.Data = []byte(`{}`)
+	}
+
+	err = json.Unmarshal(msg.Data, &q)
+	if err != nil {
+		return
+	}
+
+	sets, err = models.FindCredentialSets(q)
+	if err != nil {
+		return
+	}
+
+	for i := range sets {
+		sets[i].Redact()
+	}
+
+	data, err = json.Marshal(sets)
+}
@@ -0,0 +1,36 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// CredentialSetGet : gets a credential set with its secrets redacted
+func CredentialSetGet(msg *nats.Msg) {
+	var err error
+	var q map[string]interface{}
+	var c *models.CredentialSet
+	var data []byte
+
+	defer response(msg.Reply, &data, &err)
+
+	err = json.Unmarshal(msg.Data, &q)
+	if err != nil {
+		return
+	}
+
+	c, err = models.GetCredentialSet(q)
+	if err != nil {
+		return
+	}
+
+	c.Redact()
+
+	data, err = json.Marshal(c)
+}
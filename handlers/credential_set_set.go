@@ -0,0 +1,41 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// CredentialSetSet : creates or updates a shared credential set
+func CredentialSetSet(msg *nats.Msg) {
+	var err error
+	var c models.CredentialSet
+	var data []byte
+
+	defer response(msg.Reply, &data, &err)
+	defer audit(msg, &err)()
+
+	err = json.Unmarshal(msg.Data, &c)
+	if err != nil {
+		return
+	}
+
+	if c.ID == 0 {
+		err = c.Create()
+	} else {
+		err = c.Update()
+	}
+
+	if err != nil {
+		return
+	}
+
+	c.Redact()
+
+	data, err = json.Marshal(c)
+}
@@ -0,0 +1,40 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// CredentialSetFindUsage : lists the environments that use a credential set
+func CredentialSetFindUsage(msg *nats.Msg) {
+	var err error
+	var q map[string]interface{}
+	var c *models.CredentialSet
+	var usage []models.CredentialSetUsage
+	var data []byte
+
+	defer response(msg.Reply, &data, &err)
+
+	err = json.Unmarshal(msg.Data, &q)
+	if err != nil {
+		return
+	}
+
+	c, err = models.GetCredentialSet(q)
+	if err != nil {
+		return
+	}
+
+	usage, err = c.Usage()
+	if err != nil {
+		return
+	}
+
+	data, err = json.Marshal(usage)
+}
@@ -0,0 +1,43 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// RepairCredentials : finds and fixes environment credentials that were
+// encrypted more than once. The repair runs in the background, publishing its
+// progress after every batch
+func RepairCredentials(msg *nats.Msg) {
+	var err error
+	var req struct {
+		BatchSize int  `json:"batch_size"`
+		DryRun    bool `json:"dry_run"`
+	}
+	var data []byte
+
+	defer response(msg.Reply, &data, &err)
+	defer audit(msg, &err)()
+
+	if len(msg.Data) > 0 {
+		err = json.Unmarshal(msg.Data, &req)
+		if err != nil {
+			return
+		}
+	}
+
+	err = runCredentialsJob("environment.repair.credentials", func(progress func(models.RotationProgress)) (*models.RotationProgress, error) {
+		return models.RepairCredentials(req.BatchSize, req.DryRun, progress)
+	})
+	if err != nil {
+		return
+	}
+
+	data = []byte(`{"status": "started"}`)
+}
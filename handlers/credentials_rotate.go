@@ -0,0 +1,88 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync/atomic"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+var processingCredentials int32
+
+// credentialsJob : re-encrypts credentials, reporting its progress
+type credentialsJob func(progress func(models.RotationProgress)) (*models.RotationProgress, error)
+
+// RotateCredentials : re-encrypts all environment credentials with the
+// current key. The rotation runs in the background, publishing its progress
+// after every batch
+func RotateCredentials(msg *nats.Msg) {
+	var err error
+	var req struct {
+		BatchSize int `json:"batch_size"`
+	}
+	var data []byte
+
+	defer response(msg.Reply, &data, &err)
+	defer audit(msg, &err)()
+
+	if len(msg.Data) > 0 {
+		err = json.Unmarshal(msg.Data, &req)
+		if err != nil {
+			return
+		}
+	}
+
+	err = runCredentialsJob("environment.rotate.credentials", func(progress func(models.RotationProgress)) (*models.RotationProgress, error) {
+		return models.RotateCredentials(req.BatchSize, progress)
+	})
+	if err != nil {
+		return
+	}
+
+	data = []byte(`{"status": "started"}`)
+}
+
+// runCredentialsJob : runs a job in the background, publishing its progress
+// on <subject>.progress and its result on <subject>.done. Only one job can
+// run at a time
+func runCredentialsJob(subject string, job credentialsJob) error {
+	if !atomic.CompareAndSwapInt32(&processingCredentials, 0, 1) {
+		return errors.New("a credential rotation or repair is already running")
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&processingCredentials, 0)
+
+		p, err := job(func(p models.RotationProgress) {
+			publishRotation(subject+".progress", p)
+		})
+
+		if err != nil {
+			log.Println("[ERROR] : " + subject + " failed: " + err.Error())
+			if p == nil {
+				return
+			}
+		}
+
+		publishRotation(subject+".done", *p)
+	}()
+
+	return nil
+}
+
+func publishRotation(subject string, p models.RotationProgress) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		log.Println("[ERROR] : " + err.Error())
+		return
+	}
+
+	pub(subject, data)
+}
@@ -25,7 +25,7 @@ func BuildGetDefinition(msg *nats.Msg) {
 		return
 	}
 
-	b, err = models.GetBuild(map[string]interface{}{"uuid": m.ID})
+	b, err = models.GetBuild(m.build())
 	if err != nil {
 		return
 	}
@@ -0,0 +1,40 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// BuildGetDrift : drift report getter
+func BuildGetDrift(msg *nats.Msg) {
+	var err error
+	var data []byte
+	var m Message
+	var b *models.Build
+	var d *models.Drift
+
+	defer response(msg.Reply, &data, &err)
+
+	err = json.Unmarshal(msg.Data, &m)
+	if err != nil {
+		return
+	}
+
+	b, err = models.GetBuild(m.build())
+	if err != nil {
+		return
+	}
+
+	d, err = b.GetDrift()
+	if err != nil {
+		return
+	}
+
+	data, err = json.Marshal(d)
+}
@@ -0,0 +1,38 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// BuildSetDrift : drift report setter
+func BuildSetDrift(msg *nats.Msg) {
+	var err error
+	var m struct {
+		ID    string        `json:"id"`
+		Drift models.Drift  `json:"drift"`
+		Scope *models.Scope `json:"_scope"`
+	}
+	var b *models.Build
+
+	defer response(msg.Reply, nil, &err)
+	defer audit(msg, &err)()
+
+	err = json.Unmarshal(msg.Data, &m)
+	if err != nil {
+		return
+	}
+
+	b, err = models.GetBuild(map[string]interface{}{"uuid": m.ID, models.ScopeField: m.Scope})
+	if err != nil {
+		return
+	}
+
+	err = b.SetDrift(&m.Drift)
+}
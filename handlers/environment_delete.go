@@ -18,6 +18,7 @@ func EnvDelete(msg *nats.Msg) {
 	var data []byte
 
 	defer response(msg.Reply, &data, &err)
+	defer audit(msg, &err)()
 
 	err = json.Unmarshal(msg.Data, &env)
 	if err != nil {
@@ -29,7 +30,7 @@ func EnvDelete(msg *nats.Msg) {
 		return
 	}
 
-	DetatchPolicies(env.Name)
+	DetatchPolicies(&env)
 
 	data = []byte(`{"status": "success"}`)
 }
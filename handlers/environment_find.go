@@ -34,5 +34,9 @@ func EnvFind(msg *nats.Msg) {
 		return
 	}
 
+	for i := range envs {
+		envs[i].Redact()
+	}
+
 	data, err = json.Marshal(envs)
 }
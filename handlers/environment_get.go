@@ -30,5 +30,7 @@ func EnvGet(msg *nats.Msg) {
 		return
 	}
 
+	env.Redact()
+
 	data, err = json.Marshal(env)
 }
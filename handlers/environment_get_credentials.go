@@ -0,0 +1,100 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// CredentialAccess : a record of a request to read decrypted credentials
+type CredentialAccess struct {
+	Service       string    `json:"service"`
+	EnvironmentID uint      `json:"environment_id,omitempty"`
+	Name          string    `json:"name,omitempty"`
+	Allowed       bool      `json:"allowed"`
+	Error         string    `json:"error,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// EnvGetCredentials : gets the decrypted credentials of an environment. Only
+// the service identities in ERNEST_CREDENTIAL_READERS can read them, on
+// requests they signed, and every request is audited
+func EnvGetCredentials(msg *nats.Msg) {
+	var err error
+	var req struct {
+		ID        uint          `json:"id"`
+		ProjectID uint          `json:"project_id"`
+		Name      string        `json:"name"`
+		Scope     *models.Scope `json:"_scope"`
+	}
+	var env *models.Environment
+	var c models.Map
+	var data []byte
+
+	defer response(msg.Reply, &data, &err)
+
+	err = json.Unmarshal(msg.Data, &req)
+	if err != nil {
+		return
+	}
+
+	a := CredentialAccess{
+		Service:       Caller(msg),
+		EnvironmentID: req.ID,
+		Name:          req.Name,
+	}
+
+	// unsigned requests have no caller, and are never allowed
+	a.Allowed = a.Service != "" && models.CredentialReaders().Contains(a.Service)
+
+	defer auditCredentialAccess(&a, &err)
+
+	if !a.Allowed {
+		err = errors.New("service is not allowed to read credentials")
+		return
+	}
+
+	if req.ID != 0 {
+		env, err = models.GetEnvironment(map[string]interface{}{"id": req.ID, models.ScopeField: req.Scope})
+	} else {
+		env, err = models.GetEnvironment(byName(req.Name, req.ProjectID, req.Scope))
+	}
+
+	if err != nil {
+		return
+	}
+
+	a.EnvironmentID = env.ID
+	a.Name = env.Name
+
+	c, err = env.DecryptedCredentials()
+	if err != nil {
+		return
+	}
+
+	data, err = json.Marshal(c)
+}
+
+func auditCredentialAccess(a *CredentialAccess, err *error) {
+	a.Timestamp = time.Now()
+	if *err != nil {
+		a.Error = (*err).Error()
+	}
+
+	data, merr := json.Marshal(a)
+	if merr != nil {
+		log.Println("[ERROR] : " + merr.Error())
+		return
+	}
+
+	log.Println("[AUDIT] : environment.get.credentials " + string(data))
+	pub("environment.credentials.accessed", data)
+}
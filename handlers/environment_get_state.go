@@ -0,0 +1,41 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// EnvGetState : gets the mapping of what is currently deployed in an
+// environment
+func EnvGetState(msg *nats.Msg) {
+	var err error
+	var q map[string]interface{}
+	var env *models.Environment
+	var b *models.Build
+	var data []byte
+
+	defer response(msg.Reply, &data, &err)
+
+	err = json.Unmarshal(msg.Data, &q)
+	if err != nil {
+		return
+	}
+
+	env, err = models.GetEnvironment(q)
+	if err != nil {
+		return
+	}
+
+	b, err = models.GetDeployedState(env.ID)
+	if err != nil {
+		return
+	}
+
+	data, err = json.Marshal(b.Mapping)
+}
@@ -0,0 +1,68 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// LockRequest : a request to lock or unlock an environment
+type LockRequest struct {
+	ID        uint          `json:"id"`
+	ProjectID uint          `json:"project_id"`
+	Name      string        `json:"name"`
+	Owner     string        `json:"owner"`
+	Reason    string        `json:"reason"`
+	TTL       string        `json:"ttl"`
+	Force     bool          `json:"force"`
+	Scope     *models.Scope `json:"_scope"`
+}
+
+func (r *LockRequest) environment() (*models.Environment, error) {
+	if r.ID != 0 {
+		return models.GetEnvironment(map[string]interface{}{"id": r.ID, models.ScopeField: r.Scope})
+	}
+	return models.GetEnvironment(byName(r.Name, r.ProjectID, r.Scope))
+}
+
+// EnvLock : locks an environment for manual work
+func EnvLock(msg *nats.Msg) {
+	var err error
+	var req LockRequest
+	var env *models.Environment
+	var ttl time.Duration
+	var data []byte
+
+	defer response(msg.Reply, &data, &err)
+	defer audit(msg, &err)()
+
+	err = json.Unmarshal(msg.Data, &req)
+	if err != nil {
+		return
+	}
+
+	if req.TTL != "" {
+		ttl, err = time.ParseDuration(req.TTL)
+		if err != nil {
+			return
+		}
+	}
+
+	env, err = req.environment()
+	if err != nil {
+		return
+	}
+
+	err = env.Lock(actor(msg, req.Owner), req.Reason, ttl)
+	if err != nil {
+		return
+	}
+
+	data, err = json.Marshal(env)
+}
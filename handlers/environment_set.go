@@ -15,19 +15,29 @@ import (
 func EnvSet(msg *nats.Msg) {
 	var err error
 	var env models.Environment
+	var req struct {
+		Username string `json:"user_name"`
+	}
 	var data []byte
 
 	defer response(msg.Reply, &data, &err)
+	defer audit(msg, &err)()
 
 	err = json.Unmarshal(msg.Data, &env)
 	if err != nil {
 		return
 	}
 
+	err = json.Unmarshal(msg.Data, &req)
+	if err != nil {
+		return
+	}
+
 	if env.ID == 0 {
 		env.Status = "initializing"
 		err = env.Create()
 	} else {
+		env.Actor = actor(msg, req.Username)
 		if env.HasChangedSchedules() {
 			defer pub("environment.set.schedules", msg.Data)
 		}
@@ -38,5 +48,7 @@ func EnvSet(msg *nats.Msg) {
 		return
 	}
 
+	env.Redact()
+
 	data, err = json.Marshal(env)
 }
@@ -0,0 +1,40 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// EnvUnlock : releases the lock on an environment
+func EnvUnlock(msg *nats.Msg) {
+	var err error
+	var req LockRequest
+	var env *models.Environment
+	var data []byte
+
+	defer response(msg.Reply, &data, &err)
+	defer audit(msg, &err)()
+
+	err = json.Unmarshal(msg.Data, &req)
+	if err != nil {
+		return
+	}
+
+	env, err = req.environment()
+	if err != nil {
+		return
+	}
+
+	err = env.Unlock(actor(msg, req.Owner), req.Force)
+	if err != nil {
+		return
+	}
+
+	data = []byte(`{"status": "success"}`)
+}
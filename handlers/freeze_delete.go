@@ -0,0 +1,40 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// FreezeDelete : deletes a change freeze
+func FreezeDelete(msg *nats.Msg) {
+	var err error
+	var f models.Freeze
+	var data []byte
+
+	defer response(msg.Reply, &data, &err)
+	defer audit(msg, &err)()
+
+	err = json.Unmarshal(msg.Data, &f)
+	if err != nil {
+		return
+	}
+
+	if f.ID == 0 {
+		err = errors.New("a valid id must be provided")
+		return
+	}
+
+	err = f.Delete()
+	if err != nil {
+		return
+	}
+
+	data = []byte(`{"status": "success"}`)
+}
@@ -0,0 +1,98 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// FreezeStatus : a freeze with its current and next windows
+type FreezeStatus struct {
+	models.Freeze
+	Active *models.Window `json:"active,omitempty"`
+	Next   *models.Window `json:"next,omitempty"`
+}
+
+// FreezeFind : lists active and upcoming change freezes. When queried by
+// environment_id the freezes of the environment's project are included
+func FreezeFind(msg *nats.Msg) {
+	var err error
+	var q map[string]interface{}
+	var freezes []models.Freeze
+	var data []byte
+
+	defer response(msg.Reply, &data, &err)
+
+	if len(msg.Data) < 1 {
+		msg.Data = []byte(`{}`)
+	}
+
+	err = json.Unmarshal(msg.Data, &q)
+	if err != nil {
+		return
+	}
+
+	horizon := time.Hour * 24 * 7
+	if h, ok := q["horizon"].(string); ok {
+		horizon, err = time.ParseDuration(h)
+		if err != nil {
+			return
+		}
+
+		if horizon > models.MaxFreezeHorizon {
+			err = errors.New("horizon can't be longer than " + models.MaxFreezeHorizon.String())
+			return
+		}
+	}
+
+	all, _ := q["all"].(bool)
+
+	if q["environment_id"] != nil {
+		var env *models.Environment
+
+		env, err = models.GetEnvironment(map[string]interface{}{"id": q["environment_id"], models.ScopeField: q[models.ScopeField]})
+		if err != nil {
+			return
+		}
+
+		freezes, err = models.EnvironmentFreezes(env)
+	} else {
+		freezes, err = models.FindFreezes(q)
+	}
+
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	statuses := []FreezeStatus{}
+
+	for _, f := range freezes {
+		fs := FreezeStatus{Freeze: f}
+
+		fs.Active, err = f.ActiveAt(now)
+		if err != nil {
+			return
+		}
+
+		fs.Next, err = f.Next(now, horizon)
+		if err != nil {
+			return
+		}
+
+		if fs.Active == nil && fs.Next == nil && !all {
+			continue
+		}
+
+		statuses = append(statuses, fs)
+	}
+
+	data, err = json.Marshal(statuses)
+}
@@ -0,0 +1,39 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// FreezeSet : creates or updates a change freeze
+func FreezeSet(msg *nats.Msg) {
+	var err error
+	var f models.Freeze
+	var data []byte
+
+	defer response(msg.Reply, &data, &err)
+	defer audit(msg, &err)()
+
+	err = json.Unmarshal(msg.Data, &f)
+	if err != nil {
+		return
+	}
+
+	if f.ID == 0 {
+		err = f.Create()
+	} else {
+		err = f.Update()
+	}
+
+	if err != nil {
+		return
+	}
+
+	data, err = json.Marshal(f)
+}
@@ -9,11 +9,19 @@ import (
 	"log"
 	"strconv"
 	"time"
+
+	"github.com/ernestio/service-store/models"
 )
 
 // Error : default error message
 type Error struct {
 	Error string `json:"_error"`
+	Code  string `json:"_code,omitempty"`
+}
+
+// coder : an error that identifies its type to callers
+type coder interface {
+	Code() string
 }
 
 // Message ...
@@ -22,11 +30,27 @@ type Message struct {
 	Definition string                 `json:"definition"`
 	Mapping    map[string]interface{} `json:"mapping"`
 	Validation map[string]interface{} `json:"validation"`
+	Scope      *models.Scope          `json:"_scope"`
+}
+
+// byName : the query of an environment by name, within a project if given
+func byName(name string, projectID uint, scope *models.Scope) map[string]interface{} {
+	q := map[string]interface{}{"name": name, models.ScopeField: scope}
+	if projectID != 0 {
+		q["project_id"] = projectID
+	}
+	return q
+}
+
+// build : the query of the build the message refers to
+func (m *Message) build() map[string]interface{} {
+	return map[string]interface{}{"uuid": m.ID, models.ScopeField: m.Scope}
 }
 
 // Role represents a user role.
 type Role struct {
-	ID int `json:"id"`
+	ID        int  `json:"id"`
+	ProjectID uint `json:"project_id"`
 }
 
 func response(reply string, data *[]byte, err *error) {
@@ -37,7 +61,11 @@ func response(reply string, data *[]byte, err *error) {
 
 	if *err != nil {
 		log.Println("[ ERROR ] " + (*err).Error())
-		rdata, _ = json.Marshal(Error{Error: (*err).Error()})
+		e := Error{Error: (*err).Error()}
+		if c, ok := (*err).(coder); ok {
+			e.Code = c.Code()
+		}
+		rdata, _ = json.Marshal(e)
 	}
 
 	if reply != "" {
@@ -51,11 +79,19 @@ func pub(subject string, data []byte) {
 	}
 }
 
-// DeleteRoles deletes all roles associated with the given environment name.
-func DeleteRoles(env string) {
+// DeleteRoles deletes all roles associated with the given environment. As
+// environment names are only unique within a project, roles of environments
+// of other projects with the same name are kept
+func DeleteRoles(env *models.Environment) {
 	var roles []Role
 
-	resp, err := NC.Request("authorization.find", []byte(`{"resource_type":"environment", "resource_id":"`+env+`"}`), time.Second*5)
+	q, _ := json.Marshal(map[string]interface{}{
+		"resource_type": "environment",
+		"resource_id":   env.Name,
+		"project_id":    env.ProjectID,
+	})
+
+	resp, err := NC.Request("authorization.find", q, time.Second*5)
 	if err != nil {
 		log.Println("[ERROR] : " + err.Error())
 		return
@@ -68,6 +104,10 @@ func DeleteRoles(env string) {
 	}
 
 	for _, role := range roles {
+		if role.ProjectID != env.ProjectID {
+			continue
+		}
+
 		_, err := NC.Request("authorization.del", []byte(`{"id":`+strconv.Itoa(role.ID)+`}`), time.Second*5)
 		if err != nil {
 			log.Println("[ERROR] : " + err.Error())
@@ -76,11 +116,17 @@ func DeleteRoles(env string) {
 	}
 }
 
-// DetatchPolicies : will detach all policies from an environment
-func DetatchPolicies(env string) {
+// DetatchPolicies : will detach all policies from an environment. Only the
+// policies of the environment's project are changed
+func DetatchPolicies(env *models.Environment) {
 	var p []map[string]interface{}
 
-	resp, err := NC.Request("policy.find", []byte(`{"environments": ["`+env+`"]}`), time.Second*5)
+	q, _ := json.Marshal(map[string]interface{}{
+		"environments": []string{env.Name},
+		"project_id":   env.ProjectID,
+	})
+
+	resp, err := NC.Request("policy.find", q, time.Second*5)
 	if err != nil {
 		log.Println("[ERROR] : " + err.Error())
 		return
@@ -99,13 +145,13 @@ func DetatchPolicies(env string) {
 	for i := 0; i < len(p); i++ {
 		var matched bool
 
-		if p[i]["environments"] == nil {
+		if p[i]["environments"] == nil || toUint(p[i]["project_id"]) != env.ProjectID {
 			continue
 		}
 
 		envs := p[i]["environments"].([]interface{})
 		for x := len(envs) - 1; x >= 0; x-- {
-			if envs[x] == env {
+			if envs[x] == env.Name {
 				matched = true
 				envs = append(envs[:x], envs[x+1:]...)
 			}
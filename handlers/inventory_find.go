@@ -0,0 +1,39 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// InventoryFind : finds components across the latest completed build of
+// every environment
+func InventoryFind(msg *nats.Msg) {
+	var err error
+	var q models.InventoryQuery
+	var items []models.InventoryItem
+	var data []byte
+
+	defer response(msg.Reply, &data, &err)
+
+	if len(msg.Data) < 1 {
+		msg.Data = []byte(`{}`)
+	}
+
+	err = json.Unmarshal(msg.Data, &q)
+	if err != nil {
+		return
+	}
+
+	items, err = models.FindInventory(q)
+	if err != nil {
+		return
+	}
+
+	data, err = json.Marshal(items)
+}
@@ -12,12 +12,17 @@ import (
 	"github.com/nats-io/go-nats"
 )
 
-// BuildGetMapping : Mapping field getter
+// BuildGetMapping : Mapping field getter. The mapping can be returned as a
+// Graphviz DOT or Mermaid diagram with the format field
 func BuildGetMapping(msg *nats.Msg) {
 	var err error
 	var data []byte
-	var m Message
+	var m struct {
+		Message
+		Format string `json:"format"`
+	}
 	var b *models.Build
+	var d string
 
 	defer response(msg.Reply, &data, &err)
 
@@ -26,7 +31,7 @@ func BuildGetMapping(msg *nats.Msg) {
 		return
 	}
 
-	b, err = models.GetBuild(map[string]interface{}{"uuid": m.ID})
+	b, err = models.GetBuild(m.build())
 	if err != nil {
 		return
 	}
@@ -36,5 +41,20 @@ func BuildGetMapping(msg *nats.Msg) {
 		return
 	}
 
-	data, err = json.Marshal(b.Mapping)
+	err = b.LoadMapping()
+	if err != nil {
+		return
+	}
+
+	if m.Format == "" || m.Format == "json" {
+		data, err = json.Marshal(b.Mapping)
+		return
+	}
+
+	d, err = b.Mapping.Diagram(m.Format)
+	if err != nil {
+		return
+	}
+
+	data = []byte(d)
 }
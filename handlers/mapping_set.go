@@ -18,13 +18,14 @@ func BuildSetMapping(msg *nats.Msg) {
 	var b *models.Build
 
 	defer response(msg.Reply, nil, &err)
+	defer audit(msg, &err)()
 
 	err = json.Unmarshal(msg.Data, &m)
 	if err != nil {
 		return
 	}
 
-	b, err = models.GetBuild(map[string]interface{}{"uuid": m.ID})
+	b, err = models.GetBuild(m.build())
 	if err != nil {
 		return
 	}
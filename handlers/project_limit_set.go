@@ -0,0 +1,40 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// ProjectSetLimit : sets the maximum number of concurrent builds of a project
+func ProjectSetLimit(msg *nats.Msg) {
+	var err error
+	var l models.ProjectLimit
+	var data []byte
+
+	defer response(msg.Reply, &data, &err)
+	defer audit(msg, &err)()
+
+	err = json.Unmarshal(msg.Data, &l)
+	if err != nil {
+		return
+	}
+
+	if l.ProjectID == 0 {
+		err = errors.New("a valid project_id must be provided")
+		return
+	}
+
+	err = l.Set()
+	if err != nil {
+		return
+	}
+
+	data, err = json.Marshal(l)
+}
@@ -0,0 +1,54 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/nats-io/go-nats"
+)
+
+// ProjectFindUsage : gets the running builds of projects against their limits
+func ProjectFindUsage(msg *nats.Msg) {
+	var err error
+	var q struct {
+		ProjectID  uint          `json:"project_id"`
+		ProjectIDs []uint        `json:"project_ids"`
+		Scope      *models.Scope `json:"_scope"`
+	}
+	var usage []models.ProjectUsage
+	var data []byte
+
+	defer response(msg.Reply, &data, &err)
+
+	if len(msg.Data) < 1 {
+		msg.Data = []byte(`{}`)
+	}
+
+	err = json.Unmarshal(msg.Data, &q)
+	if err != nil {
+		return
+	}
+
+	if q.ProjectID != 0 {
+		q.ProjectIDs = append(q.ProjectIDs, q.ProjectID)
+	}
+
+	if q.Scope.Restricted() {
+		q.ProjectIDs = q.Scope.Projects(q.ProjectIDs)
+		if len(q.ProjectIDs) < 1 {
+			data = []byte(`[]`)
+			return
+		}
+	}
+
+	usage, err = models.GetProjectUsage(q.ProjectIDs)
+	if err != nil {
+		return
+	}
+
+	data, err = json.Marshal(usage)
+}
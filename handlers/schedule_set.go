@@ -20,6 +20,7 @@ func SetSchedule(msg *nats.Msg) {
 	var req map[string]interface{}
 
 	defer response(msg.Reply, &resp, &err)
+	defer audit(msg, &err)()
 
 	err = json.Unmarshal(msg.Data, &req)
 	if err != nil {
@@ -31,7 +32,10 @@ func SetSchedule(msg *nats.Msg) {
 		return
 	}
 
-	q := map[string]interface{}{"name": req["name"]}
+	q := map[string]interface{}{"name": req["name"], models.ScopeField: req[models.ScopeField]}
+	if req["project_id"] != nil {
+		q["project_id"] = req["project_id"]
+	}
 	env, err = models.GetEnvironment(q)
 	if err != nil {
 		err = errors.New("retrieving environment info when setting a schedule")
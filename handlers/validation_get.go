@@ -26,7 +26,7 @@ func BuildGetValidation(msg *nats.Msg) {
 		return
 	}
 
-	b, err = models.GetBuild(map[string]interface{}{"uuid": m.ID})
+	b, err = models.GetBuild(m.build())
 	if err != nil {
 		return
 	}
@@ -0,0 +1,112 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInventoryFind(t *testing.T) {
+	_ = os.Setenv("ERNEST_AUTH_KEYS", "api=api-secret")
+	_ = os.Setenv("ERNEST_AUTH_TRUSTED", "api")
+	defer os.Unsetenv("ERNEST_AUTH_KEYS")
+	defer os.Unsetenv("ERNEST_AUTH_TRUSTED")
+
+	setupTestSuite("test_inventory_find")
+
+	db.Unscoped().Delete(models.Environment{}, models.Build{})
+	CreateTestData(db, 20)
+
+	db.Model(&models.Environment{}).Where("id in (?)", []uint{1, 2}).Update("project_id", 1)
+
+	var b models.Build
+	db.Where("uuid = ?", "uuid-2").First(&b)
+
+	b.Mapping = models.Map{
+		"id": "uuid-2",
+		"components": []interface{}{
+			map[string]interface{}{"_component_id": "instance::web", "_component": "instance", "name": "web", "ip": "10.0.0.5", "_state": "completed"},
+		},
+	}
+	db.Save(&b)
+
+	// builds that haven't completed aren't searched
+	db.Create(&models.Build{
+		UUID:          "uuid-21",
+		EnvironmentID: 2,
+		Status:        "in_progress",
+		Type:          "apply",
+		Mapping: models.Map{
+			"id": "uuid-21",
+			"components": []interface{}{
+				map[string]interface{}{"_component_id": "instance::web", "_component": "instance", "name": "web", "ip": "10.0.0.6", "_state": "running"},
+			},
+		},
+	})
+
+	// nor are the builds that aren't what is deployed
+	db.Create(&models.Build{
+		UUID:          "uuid-22",
+		EnvironmentID: 2,
+		Status:        "done",
+		Type:          "sync",
+		Drift:         models.Map{"resolution": "rejected"},
+		Mapping: models.Map{
+			"id": "uuid-22",
+			"components": []interface{}{
+				map[string]interface{}{"_component_id": "instance::web", "_component": "instance", "name": "web", "ip": "10.0.0.7", "_state": "completed"},
+			},
+		},
+	})
+
+	cases := []struct {
+		Name     string
+		Query    string
+		Expected []models.InventoryItem
+	}{
+		{"by-type-and-field", `{"type": "instance", "fields": {"ip": "10.0.0.5"}}`, []models.InventoryItem{
+			{ProjectID: 1, EnvironmentID: 2, EnvironmentName: "Test2", BuildID: "uuid-2", ComponentID: "instance::web", Type: "instance", Name: "web"},
+		}},
+		{"incomplete-build", `{"fields": {"ip": "10.0.0.6"}}`, []models.InventoryItem{}},
+		{"rejected-sync", `{"fields": {"ip": "10.0.0.7"}}`, []models.InventoryItem{}},
+		{"other-project", `{"type": "instance", "project_id": 2}`, []models.InventoryItem{}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var items []models.InventoryItem
+
+			resp, err := n.Request("inventory.find", []byte(tc.Query), time.Second)
+			assert.Nil(t, err)
+			assert.Nil(t, json.Unmarshal(resp.Data, &items))
+			assert.Equal(t, tc.Expected, items)
+		})
+	}
+
+	t.Run("out-of-scope", func(t *testing.T) {
+		var items []models.InventoryItem
+
+		resp, err := n.Request("inventory.find", signed("api", "inventory.find", []byte(`{"type": "instance", "_scope": {"project_ids": [2]}}`)), time.Second)
+		assert.Nil(t, err)
+		assert.Nil(t, json.Unmarshal(resp.Data, &items))
+		assert.Equal(t, []models.InventoryItem{}, items)
+	})
+
+	t.Run("every-environment", func(t *testing.T) {
+		var items []models.InventoryItem
+
+		resp, err := n.Request("inventory.find", []byte(`{"fields": {"_component_id": "network::test-1"}}`), time.Second)
+		assert.Nil(t, err)
+		assert.Nil(t, json.Unmarshal(resp.Data, &items))
+		assert.Equal(t, 19, len(items))
+		assert.Equal(t, "uuid-1", items[0].BuildID)
+	})
+}
@@ -6,9 +6,12 @@ package main
 
 import (
 	"log"
+	"os"
 	"runtime"
+	"time"
 
 	"github.com/ernestio/service-store/handlers"
+	"github.com/ernestio/service-store/models"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 	"github.com/nats-io/go-nats"
@@ -31,34 +34,72 @@ func subscriber(subs map[string]nats.MsgHandler, event string) *nats.MsgHandler
 
 func startHandler() {
 	subscribers := map[string]nats.MsgHandler{
-		"environment.get":             handlers.EnvGet,
-		"environment.del":             handlers.EnvDelete,
-		"environment.set":             handlers.EnvSet,
-		"environment.find":            handlers.EnvFind,
-		"environment.set.schedule":    handlers.SetSchedule,
-		"environment.del.schedule":    handlers.UnsetSchedule,
-		"build.get":                   handlers.BuildGet,
-		"build.del":                   handlers.BuildDelete,
-		"build.set":                   handlers.BuildSet,
-		"build.find":                  handlers.BuildFind,
-		"build.get.validation":        handlers.BuildGetValidation,
-		"build.set.validation":        handlers.BuildSetValidation,
-		"build.get.mapping":           handlers.BuildGetMapping,
-		"build.set.mapping":           handlers.BuildSetMapping,
-		"build.set.mapping.component": handlers.BuildSetComponent,
-		"build.del.mapping.component": handlers.BuildDeleteComponent,
-		"build.set.mapping.change":    handlers.BuildSetChange,
-		"build.get.definition":        handlers.BuildGetDefinition,
-		"build.set.definition":        handlers.BuildSetDefinition,
-		"build.*.done":                handlers.BuildComplete,
-		"build.*.error":               handlers.BuildError,
-		"build.set.status":            handlers.SetBuildStatus,
+		"environment.get":                handlers.EnvGet,
+		"environment.get.credentials":    handlers.EnvGetCredentials,
+		"environment.get.state":          handlers.EnvGetState,
+		"environment.del":                handlers.EnvDelete,
+		"environment.set":                handlers.EnvSet,
+		"environment.find":               handlers.EnvFind,
+		"environment.set.schedule":       handlers.SetSchedule,
+		"environment.del.schedule":       handlers.UnsetSchedule,
+		"environment.lock":               handlers.EnvLock,
+		"environment.unlock":             handlers.EnvUnlock,
+		"environment.rotate.credentials": handlers.RotateCredentials,
+		"environment.repair.credentials": handlers.RepairCredentials,
+		"build.get":                      handlers.BuildGet,
+		"build.del":                      handlers.BuildDelete,
+		"build.set":                      handlers.BuildSet,
+		"build.find":                     handlers.BuildFind,
+		"build.get.validation":           handlers.BuildGetValidation,
+		"build.set.validation":           handlers.BuildSetValidation,
+		"build.patch.validation":         handlers.BuildPatchValidation,
+		"build.get.mapping":              handlers.BuildGetMapping,
+		"build.set.mapping":              handlers.BuildSetMapping,
+		"build.patch.mapping":            handlers.BuildPatchMapping,
+		"build.set.mapping.component":    handlers.BuildSetComponent,
+		"build.get.component":            handlers.BuildGetComponent,
+		"build.find.components":          handlers.BuildFindComponents,
+		"build.get.edges":                handlers.BuildGetEdges,
+		"build.del.mapping.component":    handlers.BuildDeleteComponent,
+		"build.set.mapping.change":       handlers.BuildSetChange,
+		"build.del.mapping.change":       handlers.BuildDeleteChange,
+		"build.move.mapping.change":      handlers.BuildMoveChange,
+		"build.set.mapping.components":   handlers.BuildSetComponents,
+		"build.set.mapping.changes":      handlers.BuildSetChanges,
+		"build.get.drift":                handlers.BuildGetDrift,
+		"build.diff":                     handlers.BuildDiff,
+		"build.set.drift":                handlers.BuildSetDrift,
+		"build.get.definition":           handlers.BuildGetDefinition,
+		"build.set.definition":           handlers.BuildSetDefinition,
+		"build.*.done":                   handlers.BuildComplete,
+		"build.*.error":                  handlers.BuildError,
+		"build.set.status":               handlers.SetBuildStatus,
+		"build.get.progress":             handlers.BuildGetProgress,
+		"freeze.set":                     handlers.FreezeSet,
+		"freeze.del":                     handlers.FreezeDelete,
+		"freeze.find":                    handlers.FreezeFind,
+		"project.set.limit":              handlers.ProjectSetLimit,
+		"project.find.usage":             handlers.ProjectFindUsage,
+		"credentials.set":                handlers.CredentialSetSet,
+		"credentials.get":                handlers.CredentialSetGet,
+		"credentials.del":                handlers.CredentialSetDelete,
+		"credentials.find":               handlers.CredentialSetFind,
+		"credentials.find.usage":         handlers.CredentialSetFindUsage,
+		"audit.find":                     handlers.AuditFind,
+		"inventory.find":                 handlers.InventoryFind,
 	}
 
-	_, err := n.Subscribe(">", func(msg *nats.Msg) {
+	models.StatusChanged = handlers.PublishStatusChanged
+
+	auth, err := handlers.LoadAuthenticator()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	_, err = n.Subscribe(">", func(msg *nats.Msg) {
 		handler := subscriber(subscribers, msg.Subject)
 		if handler != nil {
-			(*handler)(msg)
+			auth.Handle(*handler)(msg)
 		}
 	})
 
@@ -67,6 +108,20 @@ func startHandler() {
 	}
 }
 
+func startExpiry() {
+	interval := time.Minute
+
+	if i, err := time.ParseDuration(os.Getenv("ERNEST_EXPIRY_INTERVAL")); err == nil {
+		interval = i
+	}
+
+	go func() {
+		for range time.Tick(interval) {
+			handlers.ExpireBuilds()
+		}
+	}()
+}
+
 func main() {
 	setupNats()
 	setupPg("environments")
@@ -77,6 +132,7 @@ func main() {
 	}
 
 	startHandler()
+	startExpiry()
 
 	runtime.Goexit()
 }
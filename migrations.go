@@ -24,7 +24,15 @@ func Migrate(db *gorm.DB) error {
 		}
 	}
 
-	return db.AutoMigrate(models.Environment{}, models.Build{}).Error
+	err := db.AutoMigrate(models.Environment{}, models.Build{}, models.Freeze{}, models.ProjectLimit{}, models.CredentialSet{}, models.AuditRecord{}, models.DeployedState{}, models.BuildComponent{}).Error
+	if err != nil {
+		return err
+	}
+
+	// environment names are unique per project. The index on (project_id, name)
+	// is created above, before the old index on name is dropped, so names stay
+	// unique while it is swapped
+	return db.Exec("DROP INDEX IF EXISTS uix_environments_name").Error
 
 	/*
 
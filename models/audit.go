@@ -0,0 +1,243 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// AuditRecordFields ...
+var AuditRecordFields = structFields(AuditRecord{})
+
+// DefaultAuditLimit : how many audit records are returned by default
+const DefaultAuditLimit = 100
+
+// Audit targets
+const (
+	AuditEnvironment   = "environment"
+	AuditBuild         = "build"
+	AuditFreeze        = "freeze"
+	AuditProject       = "project"
+	AuditCredentialSet = "credential_set"
+)
+
+// AuditRecord : a record of a mutating request, who made it and what it changed
+type AuditRecord struct {
+	ID            uint         `json:"id" gorm:"primary_key"`
+	Subject       string       `json:"subject" gorm:"index"`
+	Actor         string       `json:"actor" gorm:"index"`
+	Verified      bool         `json:"verified"`
+	TargetType    string       `json:"target_type"`
+	Target        string       `json:"target" gorm:"index"`
+	EnvironmentID uint         `json:"environment_id" gorm:"index"`
+	BuildID       string       `json:"build_id" gorm:"index"`
+	Change        string       `json:"change"`
+	Changes       AuditChanges `json:"changes" gorm:"type: jsonb not null default '[]'::jsonb"`
+	Outcome       string       `json:"outcome"`
+	Error         string       `json:"error,omitempty"`
+	CreatedAt     time.Time    `json:"created_at" gorm:"index"`
+}
+
+// AuditChanges : the fields a request changed. Only the path of structured
+// values and long strings is recorded
+type AuditChanges []FieldChange
+
+// TableName : set Entity's table name to be audit_records
+func (r *AuditRecord) TableName() string {
+	return "audit_records"
+}
+
+// Create ...
+func (r *AuditRecord) Create() error {
+	return DB.Create(r).Error
+}
+
+// FindAuditRecords : finds the audit records matching the query that were
+// created in the time range, newest first
+func FindAuditRecords(q map[string]interface{}, from, to *time.Time, limit int) ([]AuditRecord, error) {
+	records := []AuditRecord{}
+
+	if limit < 1 {
+		limit = DefaultAuditLimit
+	}
+
+	db := query(q, AuditRecordFields, []string{})
+
+	if from != nil {
+		db = db.Where("created_at >= ?", *from)
+	}
+
+	if to != nil {
+		db = db.Where("created_at < ?", *to)
+	}
+
+	err := db.Order("created_at desc, id desc").Limit(limit).Find(&records).Error
+
+	return records, err
+}
+
+// AuditSnapshot : returns the stored state of an audit target, or nil if it
+// doesn't exist. Credentials are redacted, and builds are recorded without
+// their mapping
+func AuditSnapshot(kind string, q map[string]interface{}) (map[string]interface{}, error) {
+	var x interface{}
+	var err error
+
+	if len(q) < 1 {
+		return nil, nil
+	}
+
+	switch kind {
+	case AuditEnvironment:
+		var e *Environment
+		e, err = GetEnvironment(q)
+		if err == nil {
+			e.Redact()
+			e.Builds = nil
+			x = e
+		}
+	case AuditBuild:
+		var b *Build
+		b, err = GetBuild(q)
+		if err == nil {
+			// mappings are too large to snapshot on every change of a
+			// component, so only the build row is
+			b.Mapping = nil
+			x = b
+		}
+	case AuditFreeze:
+		x, err = GetFreeze(q)
+	case AuditCredentialSet:
+		var c *CredentialSet
+		c, err = GetCredentialSet(q)
+		if err == nil {
+			c.Redact()
+			x = c
+		}
+	case AuditProject:
+		var l ProjectLimit
+		err = DB.Where("project_id = ?", q["project_id"]).First(&l).Error
+		x = &l
+	default:
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(x)
+	if err != nil {
+		return nil, err
+	}
+
+	var s map[string]interface{}
+
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(s, "updated_at")
+	delete(s, "builds")
+
+	return s, nil
+}
+
+// DiffSnapshots : summarises the difference between two snapshots of a
+// target, returning whether it was created, updated, deleted or unchanged and
+// the fields that changed
+func DiffSnapshots(before, after map[string]interface{}) (string, AuditChanges) {
+	changes := AuditChanges{}
+
+	switch {
+	case before == nil && after == nil:
+		return "unchanged", changes
+	case before == nil:
+		return "created", changes
+	case after == nil:
+		return "deleted", changes
+	}
+
+	var fields []string
+
+	for k := range before {
+		fields = append(fields, k)
+	}
+
+	for k := range after {
+		if _, ok := before[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+
+	sort.Strings(fields)
+
+	for _, k := range fields {
+		from, to := before[k], after[k]
+
+		if reflect.DeepEqual(from, to) {
+			continue
+		}
+
+		c := FieldChange{Path: k}
+
+		if scalar(from) && scalar(to) {
+			c.From = from
+			c.To = to
+		}
+
+		changes = append(changes, c)
+	}
+
+	if len(changes) < 1 {
+		return "unchanged", changes
+	}
+
+	return "updated", changes
+}
+
+func scalar(v interface{}) bool {
+	switch x := v.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	case string:
+		return len(x) <= 256
+	}
+	return true
+}
+
+// Value : marshals the changes to a jsonb array
+func (c AuditChanges) Value() (driver.Value, error) {
+	if c == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(c)
+}
+
+// Scan : unmarshals the jsonb array of changes
+func (c *AuditChanges) Scan(src interface{}) error {
+	var source []byte
+
+	switch src.(type) {
+	case string:
+		source = []byte(src.(string))
+	case []byte:
+		source = src.([]byte)
+	default:
+		return errors.New("type assertion .([]byte) & .(string) failed")
+	}
+
+	if string(source) == "null" {
+		source = []byte("[]")
+	}
+
+	return json.Unmarshal(source, c)
+}
@@ -0,0 +1,44 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	env := map[string]interface{}{"id": 1.0, "name": "test", "status": "done", "options": map[string]interface{}{"sync": true}}
+	long := strings.Repeat("x", 300)
+
+	cases := []struct {
+		Name    string
+		Before  map[string]interface{}
+		After   map[string]interface{}
+		Change  string
+		Changes AuditChanges
+	}{
+		{"created", nil, env, "created", AuditChanges{}},
+		{"deleted", env, nil, "deleted", AuditChanges{}},
+		{"unchanged", env, env, "unchanged", AuditChanges{}},
+		{
+			"updated",
+			env,
+			map[string]interface{}{"id": 1.0, "name": "test", "status": "in_progress", "options": map[string]interface{}{"sync": false}, "definition": long},
+			"updated",
+			AuditChanges{{Path: "definition"}, {Path: "options"}, {Path: "status", From: "done", To: "in_progress"}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			change, changes := DiffSnapshots(tc.Before, tc.After)
+			assert.Equal(t, tc.Change, change)
+			assert.Equal(t, tc.Changes, changes)
+		})
+	}
+}
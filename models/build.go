@@ -7,8 +7,10 @@ package models
 import (
 	"errors"
 	"log"
+	"strconv"
 	"time"
 
+	"github.com/jinzhu/gorm"
 	"github.com/r3labs/graph"
 )
 
@@ -23,13 +25,11 @@ var BuildMinimalFields = []string{
 	"username",
 	"type",
 	"status",
+	"reason",
 	"created_at",
 	"updated_at",
 }
 
-// GraphTransform : a function that can transform parts of a graph
-type GraphTransform func(g *graph.Graph, c *graph.GenericComponent) error
-
 // Build : stores build data
 type Build struct {
 	ID            uint       `json:"-" gorm:"primary_key"`
@@ -39,9 +39,13 @@ type Build struct {
 	Username      string     `json:"user_name"`
 	Type          string     `json:"type"`
 	Status        string     `json:"status"`
+	Reason        string     `json:"reason,omitempty"`
 	Definition    string     `json:"definition,omitempty" gorm:"type:text;"`
 	Mapping       Map        `json:"mapping,omitempty" gorm:"type: jsonb not null default '{}'::jsonb"`
 	Validation    Map        `json:"validation,omitempty" gorm:"type: jsonb not null default '{}'::jsonb"`
+	Drift         Map        `json:"drift,omitempty" gorm:"type: jsonb not null default '{}'::jsonb"`
+	Scope         *Scope     `json:"_scope,omitempty" gorm:"-" sql:"-"`
+	Actor         string     `json:"-" gorm:"-" sql:"-"`
 	CreatedAt     time.Time  `json:"created_at"`
 	UpdatedAt     time.Time  `json:"updated_at"`
 	DeletedAt     *time.Time `json:"-" sql:"index"`
@@ -82,6 +86,13 @@ func GetLatestBuild(envID uint) (*Build, error) {
 	return &build, err
 }
 
+// latestBuild : gets the latest build of an environment within a transaction
+func latestBuild(db *gorm.DB, envID uint) (*Build, error) {
+	var build Build
+	err := db.Where("environment_id = ?", envID).Order("created_at desc").First(&build).Error
+	return &build, err
+}
+
 // Create ...
 func (b *Build) Create() error {
 	var err error
@@ -90,13 +101,25 @@ func (b *Build) Create() error {
 	tx := DB.Begin()
 	tx.Exec("set transaction isolation level serializable")
 
+	p := StatePayload{
+		EnvironmentID: b.EnvironmentID,
+		Action:        b.Type,
+		tx:            tx,
+	}
+
+	// the build, and the one a decision was made on, are only published once
+	// they are committed
+	defer func() {
+		statusChanged(err, append(p.changed, b)...)
+	}()
+
 	defer func() {
 		switch err {
 		case nil:
 			err = tx.Commit().Error
 		default:
 			log.Println(err)
-			err = tx.Rollback().Error
+			tx.Rollback()
 		}
 	}()
 
@@ -106,10 +129,26 @@ func (b *Build) Create() error {
 		return err
 	}
 
-	p := StatePayload{
-		EnvironmentID: env.ID,
-		Action:        b.Type,
-		tx:            tx,
+	err = b.Scope.Check(env.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	err = env.checkLock(b.Actor)
+	if err != nil {
+		return err
+	}
+
+	err = checkFreezes(tx, &env, b.Type, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if List(RunningActions).Contains(b.Type) {
+		err = checkProjectLimit(tx, &env)
+		if err != nil {
+			return err
+		}
 	}
 
 	// State machine handles state transition and committing on a successful state change
@@ -121,7 +160,28 @@ func (b *Build) Create() error {
 
 	b.Status = env.Status
 
-	return DB.Create(b).Error
+	mapping := b.Mapping
+
+	rest, rows, split := splitMapping(mapping)
+	if !split {
+		err = tx.Create(b).Error
+		return err
+	}
+
+	// the build is stored without its components and changes, which are
+	// stored as rows of their own
+	b.Mapping = rest
+
+	err = tx.Create(b).Error
+	b.Mapping = mapping
+
+	if err != nil {
+		return err
+	}
+
+	err = replaceMappingRows(tx, b.ID, rows)
+
+	return err
 }
 
 // Update ...
@@ -133,25 +193,72 @@ func (b *Build) Update() error {
 		return err
 	}
 
+	err = b.Scope.CheckEnvironment(DB, stored.EnvironmentID)
+	if err != nil {
+		return err
+	}
+
+	changed := b.Status != "" && b.Status != stored.Status
+
 	if b.Status != "" {
 		stored.Status = b.Status
 	}
 	if b.Definition != "" {
 		stored.Definition = b.Definition
 	}
+	// a new mapping replaces every component and change row, including when
+	// it has none
+	rest, rows, split := splitMapping(b.Mapping)
 	if b.Mapping != nil {
-		stored.Mapping = b.Mapping
+		stored.Mapping = rest
+		split = true
 	}
 	if b.Validation != nil {
 		stored.Validation = b.Validation
 	}
+	if b.Drift != nil {
+		stored.Drift = b.Drift
+	}
+
+	err = saveBuild(&stored, rows, split)
+	if err != nil {
+		return err
+	}
+
+	// a change of status or drift resolution can change what is deployed
+	_, err = refreshDeployedState(DB, stored.EnvironmentID)
+
+	if changed {
+		statusChanged(err, &stored)
+	}
 
-	return DB.Save(&stored).Error
+	return err
 }
 
 // Delete ...
 func (b *Build) Delete() error {
-	return DB.Delete(b).Error
+	var stored Build
+
+	err := DB.Where("uuid = ?", b.UUID).First(&stored).Error
+	if err != nil && b.Scope.Restricted() {
+		return err
+	}
+
+	if err == nil {
+		err = b.Scope.CheckEnvironment(DB, stored.EnvironmentID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = DB.Delete(b).Error
+	if err != nil || stored.EnvironmentID == 0 {
+		return err
+	}
+
+	_, err = refreshDeployedState(DB, stored.EnvironmentID)
+
+	return err
 }
 
 // SetStatus : sets the status of a build and its respective environment
@@ -161,6 +268,10 @@ func (b *Build) SetStatus(id string, status string) error {
 	tx := DB.Begin()
 	tx.Exec("set transaction isolation level serializable")
 
+	defer func() {
+		statusChanged(err, b)
+	}()
+
 	defer func() {
 		switch err {
 		case nil:
@@ -176,78 +287,130 @@ func (b *Build) SetStatus(id string, status string) error {
 		return err
 	}
 
+	err = b.Scope.CheckEnvironment(tx, b.EnvironmentID)
+	if err != nil {
+		return err
+	}
+
 	err = tx.Exec("UPDATE builds SET status = ? WHERE id = ?", status, b.ID).Error
 	if err != nil {
 		log.Println("could not update build status")
 		return err
 	}
 
+	b.Status = status
+
+	if status == "in_progress" {
+		var env Environment
+
+		err = tx.Raw("SELECT * FROM environments WHERE id = ? for update", b.EnvironmentID).Scan(&env).Error
+		if err != nil {
+			return err
+		}
+
+		err = checkProjectLimit(tx, &env)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = refreshDeployedState(tx, b.EnvironmentID)
+	if err != nil {
+		return err
+	}
+
 	err = tx.Exec("UPDATE environments SET status = ?,updated_at=now() WHERE id = ?", status, b.EnvironmentID).Error
 
 	return err
 }
 
-// SetLatestBuildStatus : sets the latest build's status
-func SetLatestBuildStatus(envID uint, status string) error {
-	pb, err := GetLatestBuild(envID)
+// SetLatestBuildStatus : sets the latest build's status, returning the build.
+// It's written on the given transaction, as the environment's state changes
+// with it
+func SetLatestBuildStatus(db *gorm.DB, envID uint, status string) (*Build, error) {
+	pb, err := latestBuild(db, envID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	err = db.Model(pb).UpdateColumns(map[string]interface{}{
+		"status":     status,
+		"updated_at": time.Now(),
+	}).Error
+
+	if err != nil {
+		return nil, err
 	}
 
-	pb.Status = status
+	_, err = refreshDeployedState(db, envID)
 
-	return pb.Update()
+	return pb, err
 }
 
 // SetComponent : creates or updates a component
 func (b *Build) SetComponent(c *graph.GenericComponent) error {
-	return b.updateGraph(c, func(g *graph.Graph, c *graph.GenericComponent) error {
-		if g.HasComponent(c.GetID()) {
-			g.UpdateComponent(c)
-			return nil
-		}
-		return g.AddComponent(c)
-	})
+	id, err := b.componentBuild(c)
+	if err != nil {
+		return err
+	}
+
+	return upsertComponent(DB, b.ID, ComponentKind, id, c)
 }
 
 // DeleteComponent : updates a component
 func (b *Build) DeleteComponent(c *graph.GenericComponent) error {
-	return b.updateGraph(c, func(g *graph.Graph, c *graph.GenericComponent) error {
-		g.DeleteComponent(c)
-		return nil
-	})
+	id, err := b.componentBuild(c)
+	if err != nil {
+		return err
+	}
+
+	return deleteComponent(DB, b.ID, ComponentKind, id)
 }
 
-// SetChange : updates a change
+// SetChange : updates a change. Changes that don't exist are only created,
+// after the others, if _upsert is set
 func (b *Build) SetChange(c *graph.GenericComponent) error {
-	return b.updateGraph(c, func(g *graph.Graph, c *graph.GenericComponent) error {
-		for i := 0; i < len(g.Changes); i++ {
-			if g.Changes[i].GetID() == c.GetID() {
-				g.Changes[i] = c
-				return nil
-			}
-		}
-		return errors.New("change component not found")
-	})
+	upsert, _ := (*c)["_upsert"].(bool)
+	delete(*c, "_upsert")
+
+	id, err := b.componentBuild(c)
+	if err != nil {
+		return err
+	}
+
+	if upsert {
+		return upsertComponent(DB, b.ID, ChangeKind, id, c)
+	}
+
+	found, err := updateComponent(DB, b.ID, ChangeKind, id, c)
+	if err == nil && !found {
+		err = errors.New("change component not found")
+	}
+
+	return err
 }
 
 // DeleteChange : deletes a change
 func (b *Build) DeleteChange(c *graph.GenericComponent) error {
-	return b.updateGraph(c, func(g *graph.Graph, c *graph.GenericComponent) error {
-		for i := len(g.Changes) - 1; i >= 0; i-- {
-			if g.Changes[i].GetID() == c.GetID() {
-				g.Changes = append(g.Changes[:i], g.Changes[i+1:]...)
-			}
-		}
-		return nil
-	})
+	id, err := b.componentBuild(c)
+	if err != nil {
+		return err
+	}
+
+	return deleteComponent(DB, b.ID, ChangeKind, id)
 }
 
-func (b *Build) updateGraph(c *graph.GenericComponent, tf GraphTransform) error {
-	var err error
+// MoveChange : moves a change to another position of the list of changes,
+// shifting the ones in between
+func (b *Build) MoveChange(c *graph.GenericComponent, position int) error {
+	var rows []BuildComponent
+
+	id, err := b.componentBuild(c)
+	if err != nil {
+		return err
+	}
 
 	tx := DB.Begin()
-	tx.Exec("set transaction isolation level serializable")
 
 	defer func() {
 		switch err {
@@ -258,27 +421,78 @@ func (b *Build) updateGraph(c *graph.GenericComponent, tf GraphTransform) error
 		}
 	}()
 
-	err = tx.Raw("SELECT * FROM builds WHERE uuid = ? for update", (*c)["service"]).Scan(b).Error
+	err = tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("build_id = ? AND kind = ?", b.ID, ChangeKind).
+		Order("position, id").
+		Find(&rows).
+		Error
+
 	if err != nil {
 		return err
 	}
 
-	g := graph.New()
+	from := -1
+	for i, r := range rows {
+		if r.ComponentID == id {
+			from = i
+		}
+	}
 
-	err = g.Load(b.Mapping)
-	if err != nil {
+	if from < 0 {
+		err = errors.New("change component not found")
 		return err
 	}
 
-	// run graph transform function
-	err = tf(g, c)
-	if err != nil {
+	if position < 0 || position >= len(rows) {
+		err = errors.New("change position " + strconv.Itoa(position) + " is out of range")
 		return err
 	}
 
-	b.Mapping.LoadGraph(g)
+	moved := rows[from]
+	rows = append(rows[:from], rows[from+1:]...)
+	rows = append(rows[:position], append([]BuildComponent{moved}, rows[position:]...)...)
+
+	// positions are renumbered, as deleted changes leave gaps
+	for i, r := range rows {
+		if r.Position == i {
+			continue
+		}
+
+		err = tx.Model(&BuildComponent{ID: r.ID}).UpdateColumn("position", i).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// saveBuild : saves a build, replacing its components and changes if its
+// mapping was split
+func saveBuild(b *Build, rows []BuildComponent, split bool) error {
+	var err error
+
+	if !split {
+		return DB.Save(b).Error
+	}
+
+	tx := DB.Begin()
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit().Error
+		default:
+			tx.Rollback()
+		}
+	}()
 
 	err = tx.Save(b).Error
+	if err != nil {
+		return err
+	}
+
+	err = replaceMappingRows(tx, b.ID, rows)
 
 	return err
 }
@@ -0,0 +1,191 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ComponentFilter : the components to find in a mapping. Fields are matched
+// by their path, with the fields of nested maps separated by dots. A list of
+// values matches any of them, and a field holding a list matches if any of
+// its items do
+type ComponentFilter struct {
+	Type     string                 `json:"type"`
+	Provider string                 `json:"provider"`
+	State    string                 `json:"_state"`
+	Fields   map[string]interface{} `json:"fields"`
+}
+
+// ComponentEdges : the edges of a component. Its dependencies are the
+// sources of the edges to it, and its dependents the destinations of the
+// edges from it
+type ComponentEdges struct {
+	ID           string   `json:"id"`
+	Dependencies []string `json:"dependencies"`
+	Dependents   []string `json:"dependents"`
+	Edges        []Edge   `json:"edges"`
+}
+
+// ComponentNotFoundError : a component that is not part of a mapping
+type ComponentNotFoundError struct {
+	ID string
+}
+
+func (e *ComponentNotFoundError) Error() string {
+	return "component " + e.ID + " not found"
+}
+
+// Code ...
+func (e *ComponentNotFoundError) Code() string {
+	return "not_found"
+}
+
+// Component : gets a component of the mapping by its id
+func (m Map) Component(id string) (map[string]interface{}, error) {
+	nm, err := normaliseMapping(m)
+	if err != nil {
+		return nil, err
+	}
+
+	c, ok := componentsByID(nm["components"])[id]
+	if !ok {
+		return nil, &ComponentNotFoundError{ID: id}
+	}
+
+	return c, nil
+}
+
+// FindComponents : finds the components of the mapping matching the filter,
+// ordered by id
+func (m Map) FindComponents(f ComponentFilter) ([]map[string]interface{}, error) {
+	found := []map[string]interface{}{}
+
+	nm, err := normaliseMapping(m)
+	if err != nil {
+		return nil, err
+	}
+
+	components := componentsByID(nm["components"])
+
+	for _, id := range sortedKeys(components) {
+		if f.Matches(components[id]) {
+			found = append(found, components[id])
+		}
+	}
+
+	return found, nil
+}
+
+// ComponentEdges : gets the edges of a component of the mapping and the
+// components on the other end of them
+func (m Map) ComponentEdges(id string) (*ComponentEdges, error) {
+	ce := ComponentEdges{
+		ID:           id,
+		Dependencies: []string{},
+		Dependents:   []string{},
+		Edges:        []Edge{},
+	}
+
+	nm, err := normaliseMapping(m)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := componentsByID(nm["components"])[id]; !ok {
+		return nil, &ComponentNotFoundError{ID: id}
+	}
+
+	for _, e := range sortedEdges(edgeSet(nm["edges"])) {
+		switch id {
+		case e.Destination:
+			ce.Dependencies = append(ce.Dependencies, e.Source)
+		case e.Source:
+			ce.Dependents = append(ce.Dependents, e.Destination)
+		default:
+			continue
+		}
+
+		ce.Edges = append(ce.Edges, e)
+	}
+
+	sort.Strings(ce.Dependencies)
+	sort.Strings(ce.Dependents)
+
+	return &ce, nil
+}
+
+// Matches : checks if a component matches the filter
+func (f ComponentFilter) Matches(c map[string]interface{}) bool {
+	fields := map[string]interface{}{}
+
+	for k, v := range f.Fields {
+		fields[k] = v
+	}
+
+	if f.Type != "" {
+		fields["_component"] = f.Type
+	}
+
+	if f.Provider != "" {
+		fields["_provider"] = f.Provider
+	}
+
+	if f.State != "" {
+		fields["_state"] = f.State
+	}
+
+	for path, want := range fields {
+		v, ok := fieldAt(c, path)
+		if !ok || !matchValue(v, want) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fieldAt : gets the value of a field by its dotted path
+func fieldAt(c map[string]interface{}, path string) (interface{}, bool) {
+	var v interface{} = c
+
+	for _, k := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		v, ok = m[k]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+func matchValue(v, want interface{}) bool {
+	if values, ok := want.([]interface{}); ok {
+		for _, w := range values {
+			if matchValue(v, w) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if items, ok := v.([]interface{}); ok {
+		for _, x := range items {
+			if matchValue(x, want) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return reflect.DeepEqual(v, want)
+}
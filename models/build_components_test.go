@@ -0,0 +1,76 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var componentsMapping = `{
+	"id": "uuid-1",
+	"components": [
+		{"_component_id": "network::a", "_component": "network", "_provider": "aws", "name": "a", "_state": "completed"},
+		{"_component_id": "instance::web-1", "_component": "instance", "_provider": "aws", "name": "web-1", "ip": "10.0.0.11", "tags": {"role": "web"}, "_state": "completed"},
+		{"_component_id": "instance::db-1", "_component": "instance", "_provider": "aws", "name": "db-1", "ip": "10.0.0.21", "tags": {"role": "db"}, "_state": "errored"},
+		{"_component_id": "firewall::web", "_component": "firewall", "_provider": "aws", "name": "web", "ports": [80, 443], "_state": "completed"}
+	],
+	"edges": [
+		{"source": "network::a", "destination": "instance::web-1", "length": 1},
+		{"source": "network::a", "destination": "instance::db-1", "length": 1},
+		{"source": "firewall::web", "destination": "instance::web-1", "length": 1}
+	]
+}`
+
+func TestMappingComponent(t *testing.T) {
+	m := testMapping(t, componentsMapping)
+
+	c, err := m.Component("instance::web-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.11", c["ip"])
+
+	_, err = m.Component("instance::web-2")
+	assert.Equal(t, &ComponentNotFoundError{ID: "instance::web-2"}, err)
+}
+
+func TestMappingFindComponents(t *testing.T) {
+	m := testMapping(t, componentsMapping)
+
+	ids := func(f ComponentFilter) []string {
+		var found []string
+		components, err := m.FindComponents(f)
+		assert.Nil(t, err)
+		for _, c := range components {
+			found = append(found, c["_component_id"].(string))
+		}
+		return found
+	}
+
+	assert.Equal(t, []string{"instance::db-1", "instance::web-1"}, ids(ComponentFilter{Type: "instance"}))
+	assert.Equal(t, []string{"instance::db-1"}, ids(ComponentFilter{Type: "instance", State: "errored"}))
+	assert.Equal(t, []string{"instance::web-1"}, ids(ComponentFilter{Fields: map[string]interface{}{"tags.role": "web"}}))
+	assert.Equal(t, []string{"instance::db-1", "instance::web-1"}, ids(ComponentFilter{Fields: map[string]interface{}{"ip": []interface{}{"10.0.0.11", "10.0.0.21"}}}))
+	assert.Equal(t, []string{"firewall::web"}, ids(ComponentFilter{Fields: map[string]interface{}{"ports": float64(443)}}))
+	assert.Nil(t, ids(ComponentFilter{Provider: "azure"}))
+}
+
+func TestMappingComponentEdges(t *testing.T) {
+	m := testMapping(t, componentsMapping)
+
+	e, err := m.ComponentEdges("instance::web-1")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"firewall::web", "network::a"}, e.Dependencies)
+	assert.Equal(t, []string{}, e.Dependents)
+	assert.Equal(t, 2, len(e.Edges))
+
+	e, err = m.ComponentEdges("network::a")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, e.Dependencies)
+	assert.Equal(t, []string{"instance::db-1", "instance::web-1"}, e.Dependents)
+
+	_, err = m.ComponentEdges("network::b")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,293 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+
+	"github.com/r3labs/graph"
+)
+
+// BuildDiff : the differences between the mappings of two builds
+type BuildDiff struct {
+	From     string                   `json:"from"`
+	To       string                   `json:"to"`
+	Added    []map[string]interface{} `json:"added"`
+	Removed  []map[string]interface{} `json:"removed"`
+	Modified []ComponentChange        `json:"modified"`
+	Edges    EdgeDiff                 `json:"edges"`
+}
+
+// EdgeDiff : the edges added and removed between two mappings
+type EdgeDiff struct {
+	Added   []Edge `json:"added"`
+	Removed []Edge `json:"removed"`
+}
+
+// Edge : a dependency between two components
+type Edge struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+// Previous : gets the build of the same environment created before this one
+func (b *Build) Previous() (*Build, error) {
+	var previous Build
+
+	err := DB.Where("environment_id = ? AND created_at < ?", b.EnvironmentID, b.CreatedAt).
+		Order("created_at desc").
+		First(&previous).
+		Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &previous, nil
+}
+
+// DiffBuilds : compares the mappings of two builds. Without a from build,
+// every component of the to build is added. Fields whose path is ignored,
+// such as _state, are not compared
+func DiffBuilds(from, to *Build, ignore []string) (*BuildDiff, error) {
+	var fm Map
+
+	if from != nil {
+		err := from.LoadMapping()
+		if err != nil {
+			return nil, err
+		}
+
+		fm = from.Mapping
+	}
+
+	err := to.LoadMapping()
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := DiffMappings(fm, to.Mapping, ignore)
+	if err != nil {
+		return nil, err
+	}
+
+	if from != nil {
+		d.From = from.UUID
+	}
+
+	d.To = to.UUID
+
+	return d, nil
+}
+
+// DiffMappings : compares two mappings, returning the components that were
+// added, removed or modified and the edges that changed
+func DiffMappings(from, to Map, ignore []string) (*BuildDiff, error) {
+	d := BuildDiff{
+		Added:    []map[string]interface{}{},
+		Removed:  []map[string]interface{}{},
+		Modified: []ComponentChange{},
+		Edges:    EdgeDiff{Added: []Edge{}, Removed: []Edge{}},
+	}
+
+	fm, err := normaliseMapping(from)
+	if err != nil {
+		return nil, err
+	}
+
+	tm, err := normaliseMapping(to)
+	if err != nil {
+		return nil, err
+	}
+
+	fc := componentsByID(fm["components"])
+	tc := componentsByID(tm["components"])
+
+	for _, id := range sortedKeys(fc, tc) {
+		f, inFrom := fc[id]
+		t, inTo := tc[id]
+
+		switch {
+		case !inFrom:
+			d.Added = append(d.Added, t)
+		case !inTo:
+			d.Removed = append(d.Removed, f)
+		default:
+			changes := fieldChanges("", f, t, ignore)
+			if len(changes) > 0 {
+				d.Modified = append(d.Modified, ComponentChange{ID: id, Fields: changes})
+			}
+		}
+	}
+
+	fe := edgeSet(fm["edges"])
+	te := edgeSet(tm["edges"])
+
+	for _, e := range sortedEdges(te) {
+		if _, ok := fe[e]; !ok {
+			d.Edges.Added = append(d.Edges.Added, e)
+		}
+	}
+
+	for _, e := range sortedEdges(fe) {
+		if _, ok := te[e]; !ok {
+			d.Edges.Removed = append(d.Edges.Removed, e)
+		}
+	}
+
+	return &d, nil
+}
+
+// normaliseMapping : loads a mapping into a graph and back, so mappings
+// stored by different versions compare the same
+func normaliseMapping(m Map) (map[string]interface{}, error) {
+	var n Map
+	var out map[string]interface{}
+
+	if len(m) < 1 {
+		return map[string]interface{}{}, nil
+	}
+
+	g := graph.New()
+
+	err := g.Load(m)
+	if err != nil {
+		return nil, err
+	}
+
+	n.LoadGraph(g)
+
+	data, err := json.Marshal(n)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &out)
+
+	return out, err
+}
+
+func componentsByID(v interface{}) map[string]map[string]interface{} {
+	components := make(map[string]map[string]interface{})
+
+	list, _ := v.([]interface{})
+
+	for _, x := range list {
+		c, ok := x.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if id, _ := c["_component_id"].(string); id != "" {
+			components[id] = c
+		}
+	}
+
+	return components
+}
+
+func sortedKeys(maps ...map[string]map[string]interface{}) []string {
+	var keys []string
+
+	seen := make(map[string]bool)
+
+	for _, m := range maps {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// fieldChanges : the differences between the fields of two components.
+// Nested maps are compared field by field, other values as a whole
+func fieldChanges(prefix string, from, to map[string]interface{}, ignore []string) []FieldChange {
+	var changes []FieldChange
+
+	fields := make(map[string]bool)
+	for k := range from {
+		fields[k] = true
+	}
+	for k := range to {
+		fields[k] = true
+	}
+
+	var names []string
+	for k := range fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		path := prefix + k
+
+		if List(ignore).Contains(path) {
+			continue
+		}
+
+		f, t := from[k], to[k]
+
+		fm, fok := f.(map[string]interface{})
+		tm, tok := t.(map[string]interface{})
+
+		if fok && tok {
+			changes = append(changes, fieldChanges(path+".", fm, tm, ignore)...)
+			continue
+		}
+
+		if !reflect.DeepEqual(f, t) {
+			changes = append(changes, FieldChange{Path: path, From: f, To: t})
+		}
+	}
+
+	return changes
+}
+
+func edgeSet(v interface{}) map[Edge]bool {
+	edges := make(map[Edge]bool)
+
+	list, _ := v.([]interface{})
+
+	for _, x := range list {
+		e, ok := x.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		src, _ := e["source"].(string)
+		dst, _ := e["destination"].(string)
+
+		if src != "" && dst != "" {
+			edges[Edge{Source: src, Destination: dst}] = true
+		}
+	}
+
+	return edges
+}
+
+func sortedEdges(edges map[Edge]bool) []Edge {
+	var sorted []Edge
+
+	for e := range edges {
+		sorted = append(sorted, e)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Source != sorted[j].Source {
+			return sorted[i].Source < sorted[j].Source
+		}
+		return sorted[i].Destination < sorted[j].Destination
+	})
+
+	return sorted
+}
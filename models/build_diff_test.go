@@ -0,0 +1,75 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testMapping(t *testing.T, data string) Map {
+	var m Map
+	assert.Nil(t, json.Unmarshal([]byte(data), &m))
+	return m
+}
+
+func TestDiffMappings(t *testing.T) {
+	from := testMapping(t, `{
+		"id": "uuid-1",
+		"components": [
+			{"_component_id": "network::a", "_component": "network", "name": "a", "range": "10.0.0.0/24", "_state": "running"},
+			{"_component_id": "network::b", "_component": "network", "name": "b", "range": "10.1.0.0/24", "_state": "running"},
+			{"_component_id": "instance::web", "_component": "instance", "name": "web", "network": "a", "tags": {"role": "web", "env": "dev"}, "_state": "running"}
+		],
+		"edges": [
+			{"source": "network::a", "destination": "instance::web", "length": 1}
+		]
+	}`)
+
+	to := testMapping(t, `{
+		"id": "uuid-2",
+		"components": [
+			{"_component_id": "network::a", "_component": "network", "name": "a", "range": "10.0.0.0/24", "_state": "completed"},
+			{"_component_id": "network::c", "_component": "network", "name": "c", "range": "10.2.0.0/24", "_state": "completed"},
+			{"_component_id": "instance::web", "_component": "instance", "name": "web", "network": "c", "tags": {"role": "web", "env": "prod"}, "_state": "completed"}
+		],
+		"edges": [
+			{"source": "network::c", "destination": "instance::web", "length": 1}
+		]
+	}`)
+
+	d, err := DiffMappings(from, to, []string{"_state"})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 1, len(d.Added))
+	assert.Equal(t, "network::c", d.Added[0]["_component_id"])
+
+	assert.Equal(t, 1, len(d.Removed))
+	assert.Equal(t, "network::b", d.Removed[0]["_component_id"])
+
+	assert.Equal(t, []ComponentChange{
+		{
+			ID: "instance::web",
+			Fields: []FieldChange{
+				{Path: "network", From: "a", To: "c"},
+				{Path: "tags.env", From: "dev", To: "prod"},
+			},
+		},
+	}, d.Modified)
+
+	assert.Equal(t, []Edge{{Source: "network::c", Destination: "instance::web"}}, d.Edges.Added)
+	assert.Equal(t, []Edge{{Source: "network::a", Destination: "instance::web"}}, d.Edges.Removed)
+
+	d, err = DiffMappings(from, to, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(d.Modified))
+
+	d, err = DiffMappings(nil, to, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(d.Added))
+	assert.Equal(t, 0, len(d.Removed))
+}
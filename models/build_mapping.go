@@ -0,0 +1,322 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/r3labs/graph"
+)
+
+// Kinds of mapping rows
+const (
+	ComponentKind = "component"
+	ChangeKind    = "change"
+)
+
+// mappingKinds : the mapping field each kind of row is assembled into
+var mappingKinds = []struct {
+	Field string
+	Kind  string
+}{
+	{"components", ComponentKind},
+	{"changes", ChangeKind},
+}
+
+// BuildComponent : a component or change of a build's mapping. They are
+// stored as rows of their own, so updating one doesn't rewrite the mapping
+// or contend with updates to the others
+type BuildComponent struct {
+	ID          uint      `json:"-" gorm:"primary_key"`
+	BuildID     uint      `json:"build_id" gorm:"unique_index:idx_build_components_component"`
+	Kind        string    `json:"kind" gorm:"unique_index:idx_build_components_component"`
+	ComponentID string    `json:"_component_id" gorm:"unique_index:idx_build_components_component"`
+	Position    int       `json:"position"`
+	Data        Map       `json:"data" gorm:"type: jsonb not null default '{}'::jsonb"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// TableName : set Entity's table name to be build_components
+func (c *BuildComponent) TableName() string {
+	return "build_components"
+}
+
+// LoadMapping : assembles the build's mapping from its components and changes
+func (b *Build) LoadMapping() error {
+	builds := []Build{*b}
+
+	err := loadMappings(builds)
+	if err != nil {
+		return err
+	}
+
+	b.Mapping = builds[0].Mapping
+
+	return nil
+}
+
+// loadMappings : assembles the mappings of builds from their components and
+// changes. Builds stored before components had rows of their own still have
+// them in their mapping, and are left as they are
+func loadMappings(builds []Build) error {
+	var ids []uint
+	var rows []BuildComponent
+
+	for _, b := range builds {
+		if !unsplit(b.Mapping) && b.ID != 0 {
+			ids = append(ids, b.ID)
+		}
+	}
+
+	if len(ids) < 1 {
+		return nil
+	}
+
+	err := DB.Where("build_id in (?)", ids).Order("build_id, kind, position, id").Find(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	byBuild := make(map[uint][]BuildComponent)
+	for _, r := range rows {
+		byBuild[r.BuildID] = append(byBuild[r.BuildID], r)
+	}
+
+	for i := range builds {
+		if !unsplit(builds[i].Mapping) {
+			builds[i].Mapping = assembleMapping(builds[i].Mapping, byBuild[builds[i].ID])
+		}
+	}
+
+	return nil
+}
+
+// assembleMapping : adds the components and changes of a build to the rest
+// of its mapping. Rows are expected in order of their position
+func assembleMapping(rest Map, rows []BuildComponent) Map {
+	if len(rest) < 1 && len(rows) < 1 {
+		return rest
+	}
+
+	m := Map{}
+	for k, v := range rest {
+		m[k] = v
+	}
+
+	for _, mk := range mappingKinds {
+		m[mk.Field] = []interface{}{}
+	}
+
+	for _, r := range rows {
+		for _, mk := range mappingKinds {
+			if r.Kind == mk.Kind {
+				m[mk.Field] = append(m[mk.Field].([]interface{}), map[string]interface{}(r.Data))
+			}
+		}
+	}
+
+	return m
+}
+
+// unsplit : checks if a mapping holds its components or changes
+func unsplit(m Map) bool {
+	for _, mk := range mappingKinds {
+		if _, ok := m[mk.Field]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// splitMapping : separates the components and changes of a mapping from the
+// rest of it, returning whether it had any
+func splitMapping(m Map) (Map, []BuildComponent, bool) {
+	var rows []BuildComponent
+
+	if !unsplit(m) {
+		return m, nil, false
+	}
+
+	rest := Map{}
+	for k, v := range m {
+		rest[k] = v
+	}
+
+	for _, mk := range mappingKinds {
+		var components []Map
+
+		delete(rest, mk.Field)
+
+		// components can be held as maps or graph components, so they are
+		// converted through json
+		data, err := json.Marshal(m[mk.Field])
+		if err != nil {
+			continue
+		}
+
+		_ = json.Unmarshal(data, &components)
+
+		for i, c := range components {
+			id, _ := c["_component_id"].(string)
+			rows = append(rows, BuildComponent{Kind: mk.Kind, ComponentID: id, Position: i, Data: c})
+		}
+	}
+
+	return rest, rows, true
+}
+
+// replaceMappingRows : replaces the components and changes of a build
+func replaceMappingRows(tx *gorm.DB, buildID uint, rows []BuildComponent) error {
+	err := tx.Where("build_id = ?", buildID).Delete(BuildComponent{}).Error
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]int)
+
+	for _, r := range rows {
+		if r.ComponentID == "" {
+			return errors.New("mapping " + r.Kind + " has no _component_id")
+		}
+
+		// a component listed twice keeps its first position and last value
+		if i, ok := seen[r.Kind+":"+r.ComponentID]; ok {
+			err = tx.Model(&BuildComponent{ID: uint(i)}).UpdateColumn("data", r.Data).Error
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		r.BuildID = buildID
+
+		err = tx.Create(&r).Error
+		if err != nil {
+			return err
+		}
+
+		seen[r.Kind+":"+r.ComponentID] = int(r.ID)
+	}
+
+	return nil
+}
+
+// componentBuild : loads the build a mapping component belongs to, returning
+// the component's id
+func (b *Build) componentBuild(c *graph.GenericComponent) (string, error) {
+	id, err := componentID(c)
+	if err != nil {
+		return "", err
+	}
+
+	uuid, _ := (*c)["service"].(string)
+
+	return id, b.mappingBuild(uuid)
+}
+
+// mappingBuild : loads the build whose mapping components are being changed,
+// checking that the caller can change it. The components of builds stored
+// before they had rows of their own are moved to them first
+func (b *Build) mappingBuild(uuid string) error {
+	var ref struct {
+		ID            uint
+		EnvironmentID uint
+		Unsplit       bool
+	}
+
+	err := DB.Raw(`SELECT id, environment_id, (mapping->'components' IS NOT NULL OR mapping->'changes' IS NOT NULL) AS unsplit
+		FROM builds WHERE uuid = ? AND deleted_at IS NULL`, uuid).Scan(&ref).Error
+	if err != nil {
+		return err
+	}
+
+	err = b.Scope.CheckEnvironment(DB, ref.EnvironmentID)
+	if err != nil {
+		return err
+	}
+
+	b.ID = ref.ID
+	b.UUID = uuid
+	b.EnvironmentID = ref.EnvironmentID
+
+	if ref.Unsplit {
+		return splitStoredMapping(b.ID)
+	}
+
+	return nil
+}
+
+func componentID(c *graph.GenericComponent) (string, error) {
+	id, _ := (*c)["_component_id"].(string)
+	if id == "" {
+		return "", errors.New("component has no _component_id")
+	}
+	return id, nil
+}
+
+// splitStoredMapping : moves the components and changes of a stored mapping
+// to rows of their own
+func splitStoredMapping(id uint) error {
+	var err error
+	var stored Build
+
+	tx := DB.Begin()
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit().Error
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	err = tx.Raw("SELECT * FROM builds WHERE id = ? for update", id).Scan(&stored).Error
+	if err != nil {
+		return err
+	}
+
+	rest, rows, split := splitMapping(stored.Mapping)
+	if !split {
+		// split by a concurrent update
+		return nil
+	}
+
+	err = replaceMappingRows(tx, id, rows)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Model(&stored).UpdateColumn("mapping", rest).Error
+
+	return err
+}
+
+// upsertComponent : creates a component or change, or replaces it if it
+// exists. New ones are added after the others
+func upsertComponent(db *gorm.DB, buildID uint, kind, id string, c *graph.GenericComponent) error {
+	return db.Exec(`INSERT INTO build_components (build_id, kind, component_id, position, data, updated_at)
+		VALUES (?, ?, ?, (SELECT coalesce(max(position) + 1, 0) FROM build_components WHERE build_id = ? AND kind = ?), ?, now())
+		ON CONFLICT (build_id, kind, component_id) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at`,
+		buildID, kind, id, buildID, kind, Map(*c)).Error
+}
+
+// updateComponent : replaces a component or change, returning whether it
+// exists
+func updateComponent(db *gorm.DB, buildID uint, kind, id string, c *graph.GenericComponent) (bool, error) {
+	u := db.Model(BuildComponent{}).
+		Where("build_id = ? AND kind = ? AND component_id = ?", buildID, kind, id).
+		UpdateColumns(map[string]interface{}{"data": Map(*c), "updated_at": time.Now()})
+
+	return u.RowsAffected > 0, u.Error
+}
+
+// deleteComponent : deletes a component or change
+func deleteComponent(db *gorm.DB, buildID uint, kind, id string) error {
+	return db.Where("build_id = ? AND kind = ? AND component_id = ?", buildID, kind, id).Delete(BuildComponent{}).Error
+}
@@ -0,0 +1,134 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/r3labs/graph"
+)
+
+// Batch operations
+const (
+	BatchSet    = "set"
+	BatchDelete = "delete"
+	BatchState  = "state"
+)
+
+// BatchItem : an operation on a component or change of a build's mapping.
+// Set creates or replaces a component, but only replaces a change. State
+// only updates the _state of an existing one
+type BatchItem struct {
+	Op        string                 `json:"op"`
+	Component graph.GenericComponent `json:"component"`
+}
+
+// BatchResult : the outcome of an operation of a batch
+type BatchResult struct {
+	ID     string `json:"_component_id"`
+	Op     string `json:"op"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ApplyBatch : applies a batch of operations on the components or changes of
+// the build with the given id in one transaction. Operations that fail don't
+// stop the others from being applied, and their errors are returned in their
+// result
+func (b *Build) ApplyBatch(uuid, kind string, items []BatchItem) ([]BatchResult, error) {
+	var err error
+
+	results := make([]BatchResult, len(items))
+
+	err = b.mappingBuild(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := DB.Begin()
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit().Error
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	for i := range items {
+		results[i] = BatchResult{Op: items[i].Op, Status: "ok"}
+
+		err = tx.Exec("SAVEPOINT batch_item").Error
+		if err != nil {
+			return nil, err
+		}
+
+		results[i].ID, err = componentID(&items[i].Component)
+		if err == nil {
+			err = applyBatchItem(tx, b.ID, kind, results[i].ID, &items[i])
+		}
+
+		if err != nil {
+			results[i].Status = "error"
+			results[i].Error = err.Error()
+
+			err = tx.Exec("ROLLBACK TO SAVEPOINT batch_item").Error
+			if err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		err = tx.Exec("RELEASE SAVEPOINT batch_item").Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return results, err
+}
+
+func applyBatchItem(tx *gorm.DB, buildID uint, kind, id string, item *BatchItem) error {
+	var found bool
+	var err error
+
+	c := &item.Component
+
+	switch item.Op {
+	case BatchSet:
+		if kind == ComponentKind {
+			return upsertComponent(tx, buildID, kind, id, c)
+		}
+		found, err = updateComponent(tx, buildID, kind, id, c)
+	case BatchDelete:
+		return deleteComponent(tx, buildID, kind, id)
+	case BatchState:
+		state, _ := (*c)["_state"].(string)
+		if state == "" {
+			return errors.New("component has no _state")
+		}
+
+		u := tx.Model(BuildComponent{}).
+			Where("build_id = ? AND kind = ? AND component_id = ?", buildID, kind, id).
+			UpdateColumns(map[string]interface{}{
+				"data":       gorm.Expr("jsonb_set(data, '{_state}', to_jsonb(?::text))", state),
+				"updated_at": time.Now(),
+			})
+
+		found, err = u.RowsAffected > 0, u.Error
+	default:
+		return errors.New("unknown batch operation " + item.Op)
+	}
+
+	if err == nil && !found {
+		err = &ComponentNotFoundError{ID: id}
+	}
+
+	return err
+}
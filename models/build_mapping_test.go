@@ -0,0 +1,47 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitMapping(t *testing.T) {
+	m := testMapping(t, `{
+		"id": "uuid-1",
+		"action": "service.create",
+		"components": [
+			{"_component_id": "network::a", "_state": "running"},
+			{"_component_id": "network::b", "_state": "running"}
+		],
+		"changes": [
+			{"_component_id": "network::c", "_state": "waiting"}
+		],
+		"edges": []
+	}`)
+
+	rest, rows, split := splitMapping(m)
+	assert.True(t, split)
+	assert.Equal(t, Map{"id": "uuid-1", "action": "service.create", "edges": []interface{}{}}, rest)
+
+	assert.Equal(t, 3, len(rows))
+	assert.Equal(t, ComponentKind, rows[0].Kind)
+	assert.Equal(t, "network::a", rows[0].ComponentID)
+	assert.Equal(t, 0, rows[0].Position)
+	assert.Equal(t, "network::b", rows[1].ComponentID)
+	assert.Equal(t, 1, rows[1].Position)
+	assert.Equal(t, ChangeKind, rows[2].Kind)
+	assert.Equal(t, "waiting", rows[2].Data["_state"])
+
+	// the mapping is left as it is
+	assert.NotNil(t, m["components"])
+
+	rest, rows, split = splitMapping(rest)
+	assert.False(t, split)
+	assert.Nil(t, rows)
+	assert.Equal(t, "uuid-1", rest["id"])
+}
@@ -0,0 +1,173 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// PatchMapping : applies a patch to the mapping of the build with the given
+// id while it is locked. Only the components and changes the patch changes
+// are written
+func (b *Build) PatchMapping(uuid string, p *Patch) error {
+	return b.patch(uuid, func(tx *gorm.DB) error {
+		var rows []BuildComponent
+
+		mapping := b.Mapping
+
+		// builds stored before components had rows of their own hold them in
+		// their mapping
+		if !unsplit(b.Mapping) {
+			err := tx.Set("gorm:query_option", "FOR UPDATE").
+				Where("build_id = ?", b.ID).
+				Order("kind, position, id").
+				Find(&rows).
+				Error
+
+			if err != nil {
+				return err
+			}
+
+			mapping = assembleMapping(b.Mapping, rows)
+		}
+
+		patched, err := applyPatch(p, mapping)
+		if err != nil {
+			return err
+		}
+
+		rest, changed, _ := splitMapping(patched)
+
+		err = tx.Model(b).UpdateColumn("mapping", rest).Error
+		if err != nil {
+			return err
+		}
+
+		b.Mapping = patched
+
+		return syncMappingRows(tx, b.ID, rows, changed)
+	})
+}
+
+// PatchValidation : applies a patch to the validation of the build with the
+// given id while it is locked
+func (b *Build) PatchValidation(uuid string, p *Patch) error {
+	return b.patch(uuid, func(tx *gorm.DB) error {
+		patched, err := applyPatch(p, b.Validation)
+		if err != nil {
+			return err
+		}
+
+		b.Validation = patched
+
+		return tx.Model(b).UpdateColumn("validation", patched).Error
+	})
+}
+
+// patch : locks a build while a patch is applied to it
+func (b *Build) patch(uuid string, apply func(tx *gorm.DB) error) error {
+	var err error
+
+	tx := DB.Begin()
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit().Error
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	err = tx.Raw("SELECT * FROM builds WHERE uuid = ? AND deleted_at IS NULL for update", uuid).Scan(b).Error
+	if err != nil {
+		return err
+	}
+
+	err = b.Scope.CheckEnvironment(tx, b.EnvironmentID)
+	if err != nil {
+		return err
+	}
+
+	err = apply(tx)
+
+	return err
+}
+
+// applyPatch : applies a patch to a document that has to stay an object
+func applyPatch(p *Patch, doc Map) (Map, error) {
+	patched, err := p.Apply(map[string]interface{}(doc))
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := patched.(map[string]interface{})
+	if !ok {
+		return nil, &PatchError{"the patched document must be an object"}
+	}
+
+	return Map(m), nil
+}
+
+// syncMappingRows : writes the components and changes of a build that were
+// added, changed or moved, and deletes the ones that were removed
+func syncMappingRows(tx *gorm.DB, buildID uint, stored, rows []BuildComponent) error {
+	existing := make(map[string]BuildComponent)
+	for _, r := range stored {
+		existing[r.Kind+":"+r.ComponentID] = r
+	}
+
+	seen := make(map[string]bool)
+
+	for _, r := range rows {
+		key := r.Kind + ":" + r.ComponentID
+
+		if r.ComponentID == "" {
+			return &PatchError{"mapping " + r.Kind + " has no _component_id"}
+		}
+
+		if seen[key] {
+			return &PatchError{"mapping " + r.Kind + " " + r.ComponentID + " is listed more than once"}
+		}
+
+		seen[key] = true
+
+		s, ok := existing[key]
+
+		switch {
+		case !ok:
+			r.BuildID = buildID
+			err := tx.Create(&r).Error
+			if err != nil {
+				return err
+			}
+		case s.Position != r.Position || !reflect.DeepEqual(s.Data, r.Data):
+			err := tx.Model(&BuildComponent{ID: s.ID}).UpdateColumns(map[string]interface{}{
+				"position":   r.Position,
+				"data":       r.Data,
+				"updated_at": time.Now(),
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for key, s := range existing {
+		if seen[key] {
+			continue
+		}
+
+		err := tx.Delete(&BuildComponent{ID: s.ID}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
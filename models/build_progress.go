@@ -0,0 +1,117 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import "math"
+
+var (
+	// ComponentCompletedStates : the states of changes that have been applied
+	ComponentCompletedStates = []string{"completed"}
+	// ComponentRunningStates : the states of changes being applied
+	ComponentRunningStates = []string{"running"}
+	// ComponentFailedStates : the states of changes that couldn't be applied
+	ComponentFailedStates = []string{"errored", "failed"}
+)
+
+// StatusChanged : called with each build whose status has changed, once the
+// change has been committed. It is set to publish build.status_changed events
+var StatusChanged = func(b *Build) {}
+
+// statusChanged : calls StatusChanged with the builds if err is nil, which
+// should be the outcome of the transaction that changed them
+func statusChanged(err error, builds ...*Build) {
+	if err != nil {
+		return
+	}
+
+	for _, b := range builds {
+		StatusChanged(b)
+	}
+}
+
+// BuildProgress : how far a build is through applying its changes
+type BuildProgress struct {
+	ID         string         `json:"id"`
+	Components map[string]int `json:"components"`
+	Changes    map[string]int `json:"changes"`
+	Total      int            `json:"total"`
+	Completed  int            `json:"completed"`
+	Percentage float64        `json:"percentage"`
+	Running    []string       `json:"running"`
+	Failed     []string       `json:"failed"`
+}
+
+// componentState : the state of a component or change of a mapping
+type componentState struct {
+	Kind        string
+	ComponentID string
+	State       string
+}
+
+// Progress : counts the components and changes of the build by their state.
+// Its percentage is of the changes that have completed, and builds without
+// changes are complete
+func (b *Build) Progress() (*BuildProgress, error) {
+	var states []componentState
+
+	if unsplit(b.Mapping) {
+		// builds stored before components had rows of their own hold them in
+		// their mapping
+		_, rows, _ := splitMapping(b.Mapping)
+		for _, r := range rows {
+			state, _ := r.Data["_state"].(string)
+			states = append(states, componentState{r.Kind, r.ComponentID, state})
+		}
+	} else {
+		err := DB.Table("build_components").
+			Select("kind, component_id, coalesce(data->>'_state', '') AS state").
+			Where("build_id = ?", b.ID).
+			Order("kind, position, id").
+			Scan(&states).
+			Error
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return progressOf(b.UUID, states), nil
+}
+
+func progressOf(id string, states []componentState) *BuildProgress {
+	p := BuildProgress{
+		ID:         id,
+		Components: map[string]int{},
+		Changes:    map[string]int{},
+		Running:    []string{},
+		Failed:     []string{},
+		Percentage: 100,
+	}
+
+	for _, s := range states {
+		if s.Kind == ComponentKind {
+			p.Components[s.State]++
+			continue
+		}
+
+		p.Changes[s.State]++
+		p.Total++
+
+		switch {
+		case List(ComponentCompletedStates).Contains(s.State):
+			p.Completed++
+		case List(ComponentRunningStates).Contains(s.State):
+			p.Running = append(p.Running, s.ComponentID)
+		case List(ComponentFailedStates).Contains(s.State):
+			p.Failed = append(p.Failed, s.ComponentID)
+		}
+	}
+
+	if p.Total > 0 {
+		p.Percentage = math.Floor(float64(p.Completed)/float64(p.Total)*1000) / 10
+	}
+
+	return &p
+}
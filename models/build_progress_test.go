@@ -0,0 +1,49 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildProgress(t *testing.T) {
+	b := Build{
+		UUID: "uuid-1",
+		Mapping: testMapping(t, `{
+			"components": [
+				{"_component_id": "network::a", "_state": "completed"},
+				{"_component_id": "instance::a", "_state": "running"}
+			],
+			"changes": [
+				{"_component_id": "network::a", "_state": "completed"},
+				{"_component_id": "instance::a", "_state": "running"},
+				{"_component_id": "instance::b", "_state": "errored"},
+				{"_component_id": "instance::c", "_state": "waiting"},
+				{"_component_id": "instance::d", "_state": "waiting"},
+				{"_component_id": "instance::e", "_state": "completed"}
+			]
+		}`),
+	}
+
+	p, err := b.Progress()
+	assert.Nil(t, err)
+
+	assert.Equal(t, &BuildProgress{
+		ID:         "uuid-1",
+		Components: map[string]int{"completed": 1, "running": 1},
+		Changes:    map[string]int{"completed": 2, "running": 1, "errored": 1, "waiting": 2},
+		Total:      6,
+		Completed:  2,
+		Percentage: 33.3,
+		Running:    []string{"instance::a"},
+		Failed:     []string{"instance::b"},
+	}, p)
+
+	p = progressOf("uuid-2", nil)
+	assert.Equal(t, float64(100), p.Percentage)
+	assert.Equal(t, 0, p.Total)
+}
@@ -0,0 +1,162 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// CredentialField : describes a credential field of a provider
+type CredentialField struct {
+	Type     string           `json:"type"`
+	Secret   bool             `json:"secret"`
+	Required bool             `json:"required"`
+	Fields   CredentialSchema `json:"fields,omitempty"`
+}
+
+// CredentialSchema : describes the credential fields of a provider by name.
+// Fields that are not described are treated as secret
+type CredentialSchema map[string]CredentialField
+
+// CredentialSchemas : the credential schemas of each provider type
+var CredentialSchemas = map[string]CredentialSchema{
+	"aws": {
+		"region":            {Type: "string", Required: true},
+		"access_key_id":     {Type: "string", Secret: true, Required: true},
+		"secret_access_key": {Type: "string", Secret: true, Required: true},
+	},
+	"azure": {
+		"subscription_id": {Type: "string", Secret: true, Required: true},
+		"client_id":       {Type: "string", Secret: true, Required: true},
+		"client_secret":   {Type: "string", Secret: true, Required: true},
+		"tenant_id":       {Type: "string", Secret: true, Required: true},
+		"environment":     {Type: "string", Secret: true},
+	},
+	"vcloud": {
+		"username":         {Type: "string", Required: true},
+		"password":         {Type: "string", Secret: true, Required: true},
+		"vcloud_url":       {Type: "string", Required: true},
+		"external_network": {Type: "string"},
+		"vse_url":          {Type: "string", Secret: true},
+	},
+}
+
+// legacySchema : the non-secret fields of environments of unknown provider types
+var legacySchema = CredentialSchema{
+	"region":           {Type: "string"},
+	"external_network": {Type: "string"},
+	"username":         {Type: "string"},
+	"vcloud_url":       {Type: "string"},
+}
+
+// GetCredentialSchema : returns the credential schema of a provider type.
+// Fake providers share the schema of the provider they fake
+func GetCredentialSchema(provider string) (CredentialSchema, bool) {
+	s, ok := CredentialSchemas[strings.TrimSuffix(provider, "-fake")]
+	if !ok {
+		return legacySchema, false
+	}
+	return s, true
+}
+
+// ValidateCredentials : checks the credentials against the schema of the
+// provider type. Credentials of unknown provider types are not validated
+func ValidateCredentials(provider string, c Map) error {
+	s, ok := GetCredentialSchema(provider)
+	if !ok || len(c) < 1 {
+		return nil
+	}
+
+	return s.validate("", c)
+}
+
+func (s CredentialSchema) validate(prefix string, c map[string]interface{}) error {
+	for name, f := range s {
+		v, ok := c[name]
+		if !ok || v == nil || v == "" {
+			if f.Required {
+				return errors.New("credentials field " + prefix + name + " is required")
+			}
+			continue
+		}
+
+		if !f.hasType(v) {
+			return errors.New("credentials field " + prefix + name + " must be a " + f.Type)
+		}
+
+		if nested, ok := v.(map[string]interface{}); ok && f.Fields != nil {
+			err := f.Fields.validate(prefix+name+".", nested)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (f CredentialField) hasType(v interface{}) bool {
+	switch f.Type {
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "bool":
+		_, ok := v.(bool)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "map":
+		_, ok := v.(map[string]interface{})
+		return ok
+	}
+	return true
+}
+
+// mapSecrets : replaces every secret string of the credentials, including
+// the ones in nested maps, with the result of fn
+func (s CredentialSchema) mapSecrets(c map[string]interface{}, fn func(string) (string, error)) error {
+	for name, v := range c {
+		f, described := s[name]
+
+		switch x := v.(type) {
+		case string:
+			if described && !f.Secret {
+				continue
+			}
+
+			r, err := fn(x)
+			if err != nil {
+				return err
+			}
+
+			c[name] = r
+		case map[string]interface{}:
+			err := f.Fields.mapSecrets(x, fn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// copyCredentials : deep copies credentials so they can be changed without
+// affecting the original on failure
+func copyCredentials(c Map) (Map, error) {
+	var cp Map
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &cp)
+
+	return cp, err
+}
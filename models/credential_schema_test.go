@@ -0,0 +1,63 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateCredentials(t *testing.T) {
+	cases := []struct {
+		Name        string
+		Provider    string
+		Credentials Map
+		Expected    string
+	}{
+		{"valid", "aws", Map{"region": "eu-west-1", "access_key_id": "id", "secret_access_key": "key"}, ""},
+		{"fake", "aws-fake", Map{"region": "eu-west-1", "access_key_id": "id", "secret_access_key": "key"}, ""},
+		{"missing", "aws", Map{"region": "eu-west-1", "access_key_id": "id"}, "secret_access_key is required"},
+		{"wrong-type", "azure", Map{"subscription_id": "s", "client_id": "c", "client_secret": 1.0, "tenant_id": "t"}, "client_secret must be a string"},
+		{"unknown-provider", "unknown", Map{"anything": 1.0}, ""},
+		{"empty", "vcloud", Map{}, ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := ValidateCredentials(tc.Provider, tc.Credentials)
+			if tc.Expected == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.Contains(t, err.Error(), tc.Expected)
+			}
+		})
+	}
+}
+
+func TestMapSecrets(t *testing.T) {
+	s, _ := GetCredentialSchema("aws")
+
+	c := map[string]interface{}{
+		"region":            "eu-west-1",
+		"access_key_id":     "id",
+		"secret_access_key": "key",
+		"assume_role": map[string]interface{}{
+			"role_arn": "arn",
+		},
+		"max_retries": 3.0,
+	}
+
+	err := s.mapSecrets(c, func(v string) (string, error) {
+		return "x:" + v, nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "eu-west-1", c["region"])
+	assert.Equal(t, "x:id", c["access_key_id"])
+	assert.Equal(t, "x:key", c["secret_access_key"])
+	assert.Equal(t, "x:arn", c["assume_role"].(map[string]interface{})["role_arn"])
+	assert.Equal(t, 3.0, c["max_retries"])
+}
@@ -0,0 +1,259 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// CredentialSetFields ...
+var CredentialSetFields = structFields(CredentialSet{})
+
+// CredentialSet : a named set of provider credentials stored once per project
+// and shared by the environments that reference it
+type CredentialSet struct {
+	ID          uint       `json:"id" gorm:"primary_key"`
+	ProjectID   uint       `json:"project_id" gorm:"index"`
+	Name        string     `json:"name" gorm:"type:varchar(100)"`
+	Type        string     `json:"type"`
+	Credentials Map        `json:"credentials" gorm:"type: jsonb not null default '{}'::jsonb"`
+	DataKey     string     `json:"-"`
+	Scope       *Scope     `json:"_scope,omitempty" gorm:"-" sql:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeletedAt   *time.Time `json:"-" sql:"index"`
+}
+
+// CredentialSetUsage : an environment that uses a credential set
+type CredentialSetUsage struct {
+	ID        uint   `json:"id"`
+	ProjectID uint   `json:"project_id"`
+	Name      string `json:"name"`
+}
+
+// TableName : set Entity's table name to be credential_sets
+func (c *CredentialSet) TableName() string {
+	return "credential_sets"
+}
+
+// FindCredentialSets : finds credential sets
+func FindCredentialSets(q map[string]interface{}) ([]CredentialSet, error) {
+	var sets []CredentialSet
+	err := query(q, CredentialSetFields, []string{}).Order("name").Find(&sets).Error
+	return sets, err
+}
+
+// GetCredentialSet : gets a credential set
+func GetCredentialSet(q map[string]interface{}) (*CredentialSet, error) {
+	var set CredentialSet
+	err := query(q, CredentialSetFields, []string{}).First(&set).Error
+	if err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+// Create ...
+func (c *CredentialSet) Create() error {
+	if c.ProjectID == 0 || c.Name == "" {
+		return errors.New("credential set must have a project_id and a name")
+	}
+
+	err := c.Scope.Check(c.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	var count int
+
+	err = DB.Model(&CredentialSet{}).Where("project_id = ? AND name = ?", c.ProjectID, c.Name).Count(&count).Error
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return errors.New("credential set " + c.Name + " already exists")
+	}
+
+	c.DataKey = ""
+
+	err = c.encrypt(nil)
+	if err != nil {
+		return err
+	}
+
+	return DB.Create(c).Error
+}
+
+// Update : merges the credentials into the stored set
+func (c *CredentialSet) Update() error {
+	var stored CredentialSet
+
+	err := DB.Where("id = ?", c.ID).First(&stored).Error
+	if err != nil {
+		return err
+	}
+
+	err = c.Scope.Check(stored.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	if c.Credentials != nil {
+		stored.Credentials, c.Credentials = c.Credentials, stored.Credentials
+
+		err = stored.encrypt(c.Credentials)
+		if err != nil {
+			return err
+		}
+	}
+
+	*c = stored
+
+	return DB.Save(&stored).Error
+}
+
+// Delete : deletes the set if no environment uses it
+func (c *CredentialSet) Delete() error {
+	if c.Scope.Restricted() {
+		var stored CredentialSet
+
+		err := DB.Where("id = ?", c.ID).First(&stored).Error
+		if err != nil {
+			return err
+		}
+
+		err = c.Scope.Check(stored.ProjectID)
+		if err != nil {
+			return err
+		}
+	}
+
+	usage, err := c.Usage()
+	if err != nil {
+		return err
+	}
+
+	if len(usage) > 0 {
+		return errors.New("credential set is used by " + usage[0].Name + " and " + strconv.Itoa(len(usage)-1) + " other environments")
+	}
+
+	return DB.Unscoped().Delete(c).Error
+}
+
+// Usage : lists the environments that use the set
+func (c *CredentialSet) Usage() ([]CredentialSetUsage, error) {
+	usage := []CredentialSetUsage{}
+	err := DB.Model(&Environment{}).Select("id, project_id, name").Where("credential_set_id = ?", c.ID).Order("name").Scan(&usage).Error
+	return usage, err
+}
+
+// Redact : replaces the set's secret credential values
+func (c *CredentialSet) Redact() {
+	s, _ := GetCredentialSchema(c.Type)
+
+	_ = s.mapSecrets(c.Credentials, func(v string) (string, error) {
+		return RedactedValue, nil
+	})
+}
+
+// encrypt : merges the set's plaintext credentials into the stored ones
+func (c *CredentialSet) encrypt(stored Map) error {
+	owner := c.keyOwner()
+
+	ec, err := encryptCredentials(owner, stored, c.Credentials)
+	if err != nil {
+		return err
+	}
+
+	err = ValidateCredentials(c.Type, ec)
+	if err != nil {
+		return err
+	}
+
+	c.Credentials = ec
+	c.DataKey = owner.DataKey
+
+	return nil
+}
+
+// keyOwner : the set's credentials are encrypted in the same way as an
+// environment of the same type
+func (c *CredentialSet) keyOwner() *Environment {
+	return &Environment{Type: c.Type, Credentials: c.Credentials, DataKey: c.DataKey}
+}
+
+// checkCredentialSet : checks the credential set referenced by the
+// environment exists, belongs to its project and is of its type
+func checkCredentialSet(e *Environment) error {
+	if e.CredentialSetID == 0 {
+		return nil
+	}
+
+	set, err := GetCredentialSet(map[string]interface{}{"id": e.CredentialSetID})
+	if err != nil {
+		return errors.New("credential set " + strconv.Itoa(int(e.CredentialSetID)) + " does not exist")
+	}
+
+	if set.ProjectID != e.ProjectID {
+		return errors.New("credential set " + set.Name + " belongs to another project")
+	}
+
+	if set.Type != e.Type {
+		return errors.New("credential set " + set.Name + " is of type " + set.Type + ", not " + e.Type)
+	}
+
+	return nil
+}
+
+// resolveCredentialSet : replaces the environment's credentials with the ones
+// of the credential set it uses
+func (e *Environment) resolveCredentialSet() error {
+	envs := []Environment{*e}
+
+	err := resolveCredentialSets(envs)
+	if err != nil {
+		return err
+	}
+
+	*e = envs[0]
+
+	return nil
+}
+
+// resolveCredentialSets : replaces the credentials of environments that use
+// a credential set with the set's
+func resolveCredentialSets(envs []Environment) error {
+	var ids []uint
+	var sets []CredentialSet
+
+	for _, e := range envs {
+		if e.CredentialSetID != 0 {
+			ids = append(ids, e.CredentialSetID)
+		}
+	}
+
+	if len(ids) < 1 {
+		return nil
+	}
+
+	err := DB.Where("id in (?)", ids).Find(&sets).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range envs {
+		for _, s := range sets {
+			if envs[i].CredentialSetID == s.ID {
+				envs[i].Credentials = s.Credentials
+				envs[i].DataKey = s.DataKey
+			}
+		}
+	}
+
+	return nil
+}
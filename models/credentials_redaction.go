@@ -0,0 +1,58 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"os"
+	"strings"
+)
+
+// RedactedValue : replaces secret credential values in read responses
+const RedactedValue = "[redacted]"
+
+// CredentialReaders : the service identities allowed to read decrypted
+// credentials, from the comma separated ERNEST_CREDENTIAL_READERS
+func CredentialReaders() List {
+	var readers List
+
+	for _, r := range strings.Split(os.Getenv("ERNEST_CREDENTIAL_READERS"), ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			readers = append(readers, r)
+		}
+	}
+
+	return readers
+}
+
+// Redact : replaces the environment's secret credential values
+func (e *Environment) Redact() {
+	s, _ := GetCredentialSchema(e.Type)
+
+	_ = s.mapSecrets(e.Credentials, func(v string) (string, error) {
+		return RedactedValue, nil
+	})
+}
+
+// DecryptedCredentials : returns the environment's credentials with their
+// secret values decrypted
+func (e *Environment) DecryptedCredentials() (Map, error) {
+	p, err := CredentialProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := copyCredentials(e.Credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	s, _ := GetCredentialSchema(e.Type)
+
+	err = s.mapSecrets(c, func(v string) (string, error) {
+		return unseal(p, e, v)
+	})
+
+	return c, err
+}
@@ -0,0 +1,265 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// RotationProgress : progress of re-encrypting the credentials of all
+// environments and credential sets
+type RotationProgress struct {
+	Key       string   `json:"key"`
+	Total     int      `json:"total"`
+	Processed int      `json:"processed"`
+	Changed   int      `json:"changed"`
+	Failed    []string `json:"failed"`
+	DryRun    bool     `json:"dry_run,omitempty"`
+	Done      bool     `json:"done"`
+}
+
+// credentialsFunc : changes the credentials of an environment, reporting if
+// anything changed
+type credentialsFunc func(k KeyProvider, e *Environment) (bool, error)
+
+// RotateCredentials : re-encrypts the credentials of every environment with
+// the current key, reporting progress after each batch. Environments that
+// can't be decrypted are skipped and reported as failed
+func RotateCredentials(batchSize int, progress func(RotationProgress)) (*RotationProgress, error) {
+	k, err := CredentialProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	if k.CurrentKey() == "" {
+		return nil, errors.New("a current crypto key must be set to rotate credentials")
+	}
+
+	return processCredentials(k, batchSize, false, rotateCredentials, progress)
+}
+
+// RepairCredentials : re-encrypts the credentials of every environment that
+// were encrypted more than once, or were stored before ciphertext was marked.
+// A dry run only reports the environments that need repairing
+func RepairCredentials(batchSize int, dryRun bool, progress func(RotationProgress)) (*RotationProgress, error) {
+	k, err := CredentialProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	return processCredentials(k, batchSize, dryRun, repairCredentials, progress)
+}
+
+func processCredentials(k KeyProvider, batchSize int, dryRun bool, fn credentialsFunc, progress func(RotationProgress)) (*RotationProgress, error) {
+	var lastID uint
+
+	if batchSize < 1 {
+		batchSize = 50
+	}
+
+	p := RotationProgress{Key: k.CurrentKey(), Failed: []string{}, DryRun: dryRun}
+
+	var sets int
+
+	err := DB.Model(&Environment{}).Count(&p.Total).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = DB.Model(&CredentialSet{}).Count(&sets).Error
+	if err != nil {
+		return nil, err
+	}
+
+	p.Total += sets
+
+	for _, batch := range []credentialsBatchFunc{credentialsBatch, credentialSetsBatch} {
+		lastID = 0
+
+		for {
+			n, err := batch(k, fn, lastID, batchSize, &p)
+			if err != nil {
+				return &p, err
+			}
+
+			if n == 0 {
+				break
+			}
+
+			lastID = n
+
+			if progress != nil {
+				progress(p)
+			}
+		}
+	}
+
+	p.Done = true
+
+	return &p, nil
+}
+
+// credentialsBatchFunc : processes the batch of rows after the given id,
+// returning the last id processed
+type credentialsBatchFunc func(k KeyProvider, fn credentialsFunc, after uint, size int, p *RotationProgress) (uint, error)
+
+// credentialsBatch : applies fn to the credentials of the next batch of
+// environments, returning the last environment id processed
+func credentialsBatch(k KeyProvider, fn credentialsFunc, after uint, size int, p *RotationProgress) (uint, error) {
+	var err error
+	var envs []Environment
+
+	tx := DB.Begin()
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit().Error
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	err = tx.Raw("SELECT * FROM environments WHERE id > ? AND deleted_at IS NULL ORDER BY id LIMIT ? for update", after, size).Scan(&envs).Error
+	if err != nil {
+		return 0, err
+	}
+
+	if len(envs) < 1 {
+		return 0, nil
+	}
+
+	for _, e := range envs {
+		p.Processed++
+
+		changed, ferr := fn(k, &e)
+		if ferr != nil {
+			log.Println("could not process credentials of environment " + strconv.Itoa(int(e.ID)) + ": " + ferr.Error())
+			p.Failed = append(p.Failed, e.Name)
+			continue
+		}
+
+		if !changed {
+			continue
+		}
+
+		p.Changed++
+
+		if p.DryRun {
+			continue
+		}
+
+		err = tx.Exec("UPDATE environments SET credentials = ?, data_key = ? WHERE id = ?", e.Credentials, e.DataKey, e.ID).Error
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return envs[len(envs)-1].ID, nil
+}
+
+// credentialSetsBatch : applies fn to the credentials of the next batch of
+// credential sets, returning the last set id processed
+func credentialSetsBatch(k KeyProvider, fn credentialsFunc, after uint, size int, p *RotationProgress) (uint, error) {
+	var err error
+	var sets []CredentialSet
+
+	tx := DB.Begin()
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit().Error
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	err = tx.Raw("SELECT * FROM credential_sets WHERE id > ? AND deleted_at IS NULL ORDER BY id LIMIT ? for update", after, size).Scan(&sets).Error
+	if err != nil {
+		return 0, err
+	}
+
+	if len(sets) < 1 {
+		return 0, nil
+	}
+
+	for _, s := range sets {
+		p.Processed++
+
+		owner := s.keyOwner()
+
+		changed, ferr := fn(k, owner)
+		if ferr != nil {
+			log.Println("could not process credentials of credential set " + strconv.Itoa(int(s.ID)) + ": " + ferr.Error())
+			p.Failed = append(p.Failed, "credential set "+s.Name)
+			continue
+		}
+
+		if !changed {
+			continue
+		}
+
+		p.Changed++
+
+		if p.DryRun {
+			continue
+		}
+
+		err = tx.Exec("UPDATE credential_sets SET credentials = ?, data_key = ? WHERE id = ?", owner.Credentials, owner.DataKey, s.ID).Error
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return sets[len(sets)-1].ID, nil
+}
+
+// rotateCredentials : re-encrypts the secret values of the environment's
+// credentials that are not encrypted with the current key
+func rotateCredentials(k KeyProvider, e *Environment) (bool, error) {
+	var changed bool
+	var err error
+
+	if r, ok := k.(Rewrapper); ok {
+		changed, err = r.Rewrap(e)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	c, err := copyCredentials(e.Credentials)
+	if err != nil {
+		return false, err
+	}
+
+	s, _ := GetCredentialSchema(e.Type)
+
+	err = s.mapSecrets(c, func(v string) (string, error) {
+		if v == "" || isEncrypted(v) && k.IsCurrent(e, strings.TrimPrefix(v, encryptedPrefix)) {
+			return v, nil
+		}
+
+		plain, err := unseal(k, e, v)
+		if err != nil {
+			return "", err
+		}
+
+		changed = true
+
+		return seal(k, e, plain)
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	e.Credentials = c
+
+	return changed, nil
+}
@@ -0,0 +1,208 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// encryptedPrefix : marks stored credential values that are ciphertext.
+// Values stored before the marker was introduced are unmarked ciphertext
+const encryptedPrefix = "enc:"
+
+func isEncrypted(v string) bool {
+	return strings.HasPrefix(v, encryptedPrefix)
+}
+
+// seal : encrypts a credential value and marks it as ciphertext
+func seal(k KeyProvider, e *Environment, v string) (string, error) {
+	if v == "" {
+		return v, nil
+	}
+
+	x, err := k.Encrypt(e, v)
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedPrefix + x, nil
+}
+
+// unseal : decrypts a stored credential value, marked or not
+func unseal(k KeyProvider, e *Environment, v string) (string, error) {
+	if v == "" {
+		return v, nil
+	}
+	return k.Decrypt(e, strings.TrimPrefix(v, encryptedPrefix))
+}
+
+// mergeCredentials : merges the credentials sent by a client into the stored
+// ones field by field. Secret values that are unchanged, redacted or already
+// stored are kept as they are, new plaintext values are encrypted, and null
+// values remove the field
+func mergeCredentials(k KeyProvider, e *Environment, s CredentialSchema, stored, c map[string]interface{}) (map[string]interface{}, error) {
+	merged := make(map[string]interface{})
+
+	for name, v := range stored {
+		merged[name] = v
+	}
+
+	for name, v := range c {
+		f, described := s[name]
+
+		switch x := v.(type) {
+		case nil:
+			delete(merged, name)
+		case map[string]interface{}:
+			sm, _ := stored[name].(map[string]interface{})
+
+			m, err := mergeCredentials(k, e, f.Fields, sm, x)
+			if err != nil {
+				return nil, err
+			}
+
+			merged[name] = m
+		case string:
+			if described && !f.Secret {
+				merged[name] = x
+				continue
+			}
+
+			sv, ok := stored[name].(string)
+
+			switch {
+			case x == RedactedValue && !ok:
+				delete(merged, name)
+			case x == RedactedValue, ok && x == sv:
+				// unchanged, keep the stored ciphertext
+			case isEncrypted(x):
+				return nil, errors.New("credentials field " + name + " is already encrypted and can't be set directly")
+			default:
+				sx, err := seal(k, e, x)
+				if err != nil {
+					return nil, err
+				}
+
+				merged[name] = sx
+			}
+		default:
+			merged[name] = v
+		}
+	}
+
+	return merged, nil
+}
+
+// repairCredentials : decrypts secret values that were encrypted more than
+// once, storing their plaintext encrypted once. Unmarked values are marked
+func repairCredentials(k KeyProvider, e *Environment) (bool, error) {
+	var changed bool
+
+	c, err := copyCredentials(e.Credentials)
+	if err != nil {
+		return false, err
+	}
+
+	s, _ := GetCredentialSchema(e.Type)
+
+	err = s.mapSecrets(c, func(v string) (string, error) {
+		if v == "" {
+			return v, nil
+		}
+
+		plain, err := unseal(k, e, v)
+		if err != nil {
+			return "", err
+		}
+
+		layers := 0
+
+		for looksEncrypted(k, plain) {
+			inner, err := unseal(k, e, plain)
+			if err != nil || !printable(inner) {
+				break
+			}
+
+			plain = inner
+			layers++
+		}
+
+		if layers == 0 && isEncrypted(v) {
+			return v, nil
+		}
+
+		changed = true
+
+		return seal(k, e, plain)
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	e.Credentials = c
+
+	return changed, nil
+}
+
+// looksEncrypted : checks if a decrypted value is itself ciphertext. Only
+// values carrying a marker count: the "enc:" or "dk:" prefix, or the id of a
+// known key. Unmarked values are left alone, as a plaintext secret can look
+// just like legacy ciphertext
+func looksEncrypted(k KeyProvider, v string) bool {
+	marked := isEncrypted(v)
+	v = strings.TrimPrefix(v, encryptedPrefix)
+
+	if strings.HasPrefix(v, envelopePrefix) {
+		marked = true
+		v = strings.TrimPrefix(v, envelopePrefix)
+	} else if id, x := splitKeyID(v); id != "" {
+		if !knownKeyID(k, id) {
+			return false
+		}
+		marked = true
+		v = x
+	}
+
+	if !marked || v == "" {
+		return false
+	}
+
+	_, uerr := base64.URLEncoding.DecodeString(v)
+	_, serr := base64.StdEncoding.DecodeString(v)
+
+	return uerr == nil || serr == nil
+}
+
+// knownKeyID : checks if a key id belongs to the provider's keyring
+func knownKeyID(k KeyProvider, id string) bool {
+	switch p := k.(type) {
+	case *KeyringProvider:
+		_, ok := p.Keyring.Keys[id]
+		return ok
+	case *EnvelopeProvider:
+		return knownKeyID(p.Fallback, id)
+	}
+
+	return false
+}
+
+func printable(v string) bool {
+	if v == "" || !utf8.ValidString(v) {
+		return false
+	}
+
+	for _, r := range v {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,137 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// base64Provider : a reversible stand in for a real key provider
+type base64Provider struct{}
+
+func (p base64Provider) Encrypt(e *Environment, s string) (string, error) {
+	return base64.StdEncoding.EncodeToString([]byte(s)), nil
+}
+
+func (p base64Provider) Decrypt(e *Environment, s string) (string, error) {
+	d, err := base64.StdEncoding.DecodeString(s)
+	return string(d), err
+}
+
+func (p base64Provider) IsCurrent(e *Environment, s string) bool {
+	return true
+}
+
+func (p base64Provider) CurrentKey() string {
+	return "base64"
+}
+
+func TestMergeCredentials(t *testing.T) {
+	k := base64Provider{}
+	e := &Environment{Type: "aws"}
+	s, _ := GetCredentialSchema(e.Type)
+
+	key, _ := seal(k, e, "key")
+
+	stored := map[string]interface{}{
+		"region":            "eu-west-1",
+		"access_key_id":     "enc:aWQ=",
+		"secret_access_key": key,
+		"session_token":     "enc:dG9rZW4=",
+	}
+
+	merged, err := mergeCredentials(k, e, s, stored, map[string]interface{}{
+		"region":            "us-east-1",
+		"access_key_id":     "new-id",
+		"secret_access_key": key,
+		"session_token":     nil,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "us-east-1", merged["region"])
+	assert.Equal(t, "enc:bmV3LWlk", merged["access_key_id"])
+	assert.Equal(t, key, merged["secret_access_key"])
+	assert.NotContains(t, merged, "session_token")
+
+	merged, err = mergeCredentials(k, e, s, stored, map[string]interface{}{
+		"secret_access_key": RedactedValue,
+		"other":             RedactedValue,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, key, merged["secret_access_key"])
+	assert.Equal(t, "enc:aWQ=", merged["access_key_id"])
+	assert.NotContains(t, merged, "other")
+
+	_, err = mergeCredentials(k, e, s, stored, map[string]interface{}{
+		"secret_access_key": "enc:b3RoZXI=",
+	})
+
+	assert.NotNil(t, err)
+}
+
+func TestRepairCredentials(t *testing.T) {
+	k := base64Provider{}
+	e := &Environment{Type: "aws"}
+
+	once, _ := seal(k, e, "a-long-enough-secret-value")
+	twice, _ := seal(k, e, once)
+	legacy, _ := k.Encrypt(e, "unmarked-secret")
+
+	e.Credentials = Map{
+		"region":            "eu-west-1",
+		"access_key_id":     once,
+		"secret_access_key": twice,
+		"session_token":     legacy,
+	}
+
+	changed, err := repairCredentials(k, e)
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, once, e.Credentials["access_key_id"])
+	assert.Equal(t, once, e.Credentials["secret_access_key"])
+	assert.Equal(t, "enc:"+legacy, e.Credentials["session_token"])
+
+	changed, err = repairCredentials(k, e)
+	assert.Nil(t, err)
+	assert.False(t, changed)
+
+	// a plaintext secret that happens to be valid base64 isn't decrypted
+	base64ish, _ := seal(k, e, "QUJDREVGR0hJSktMTU5PUFFSU1RVVldY")
+	e.Credentials = Map{"secret_access_key": base64ish}
+
+	changed, err = repairCredentials(k, e)
+	assert.Nil(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, base64ish, e.Credentials["secret_access_key"])
+}
+
+func TestDecryptStoredAzureCredentials(t *testing.T) {
+	k := base64Provider{}
+	e := &Environment{Type: "azure"}
+	s, _ := GetCredentialSchema(e.Type)
+
+	stored := map[string]interface{}{}
+	for name, v := range map[string]string{
+		"subscription_id": "subscription",
+		"client_id":       "client",
+		"client_secret":   "secret",
+		"tenant_id":       "tenant",
+		"environment":     "public",
+	} {
+		stored[name], _ = k.Encrypt(e, v)
+	}
+
+	err := s.mapSecrets(stored, func(v string) (string, error) {
+		return unseal(k, e, v)
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", stored["client_secret"])
+	assert.Equal(t, "public", stored["environment"])
+}
@@ -0,0 +1,120 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cron : a parsed five field cron expression (minute hour day-of-month month day-of-week)
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	anyDom, anyDow                bool
+}
+
+var cronBounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+
+func parseCron(s string) (*cron, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, errors.New("cron schedule '" + s + "' must have 5 fields")
+	}
+
+	var sets [5]uint64
+
+	for i, f := range fields {
+		set, err := parseCronField(f, cronBounds[i][0], cronBounds[i][1])
+		if err != nil {
+			return nil, errors.New("cron schedule '" + s + "': " + err.Error())
+		}
+		sets[i] = set
+	}
+
+	return &cron{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		anyDom: fields[2] == "*",
+		anyDow: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(f string, min, max int) (uint64, error) {
+	var set uint64
+
+	for _, part := range strings.Split(f, ",") {
+		step := 1
+		lo, hi := min, max
+
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s < 1 {
+				return 0, errors.New("invalid step in '" + part + "'")
+			}
+			step = s
+			part = part[:i]
+		}
+
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			r := strings.SplitN(part, "-", 2)
+			l, lerr := strconv.Atoi(r[0])
+			h, herr := strconv.Atoi(r[1])
+			if lerr != nil || herr != nil {
+				return 0, errors.New("invalid range '" + part + "'")
+			}
+			lo, hi = l, h
+		default:
+			v, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, errors.New("invalid value '" + part + "'")
+			}
+			lo, hi = v, v
+			if step > 1 {
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, errors.New("'" + part + "' is out of range")
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+
+	return set, nil
+}
+
+// matches : checks if the cron expression fires at the given minute
+func (c *cron) matches(t time.Time) bool {
+	if c.minute&(1<<uint(t.Minute())) == 0 ||
+		c.hour&(1<<uint(t.Hour())) == 0 ||
+		c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	// as with cron, a restricted day of month and day of week match either
+	switch {
+	case c.anyDom && c.anyDow:
+		return true
+	case c.anyDom:
+		return dow
+	case c.anyDow:
+		return dom
+	}
+
+	return dom || dow
+}
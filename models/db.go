@@ -49,7 +49,7 @@ func parse(f string, fields []string) string {
 }
 
 func query(q map[string]interface{}, fields, qfields []string) *gorm.DB {
-	qdb := DB
+	qdb := ScopeOf(q).apply(DB, fields)
 
 	for k, v := range q {
 		var qs string
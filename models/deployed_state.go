@@ -0,0 +1,107 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// StateActions : the build types whose mapping is what is deployed once they
+// complete successfully
+var StateActions = []string{"apply", "import", "sync"}
+
+// notRejectedSync : excludes the syncs whose drift was rejected, as what they
+// found is not what is deployed
+const notRejectedSync = "NOT (type = 'sync' AND coalesce(drift->>'resolution', '') = 'rejected')"
+
+// DeployedState : the build whose mapping is what is currently deployed in an
+// environment. It's kept up to date as builds change status, so it doesn't
+// have to be searched for on every read
+type DeployedState struct {
+	EnvironmentID uint      `json:"environment_id" gorm:"primary_key"`
+	BuildID       uint      `json:"-"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// NoDeployedStateError : an environment without a successful build
+type NoDeployedStateError struct{}
+
+func (e *NoDeployedStateError) Error() string {
+	return "environment has no successful build"
+}
+
+// Code ...
+func (e *NoDeployedStateError) Code() string {
+	return "not_found"
+}
+
+// TableName : set Entity's table name to be deployed_states
+func (s *DeployedState) TableName() string {
+	return "deployed_states"
+}
+
+// GetDeployedState : gets the build whose mapping is currently deployed in an
+// environment. Environments whose state has not been recorded yet, such as
+// ones created before it was, have it recorded on their first read
+func GetDeployedState(envID uint) (*Build, error) {
+	var s DeployedState
+	var b Build
+
+	err := DB.Where("environment_id = ?", envID).First(&s).Error
+
+	switch err {
+	case nil:
+	case gorm.ErrRecordNotFound:
+		s.BuildID, err = refreshDeployedState(DB, envID)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	if s.BuildID == 0 {
+		return nil, &NoDeployedStateError{}
+	}
+
+	err = DB.Where("id = ?", s.BuildID).First(&b).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &b, b.LoadMapping()
+}
+
+// refreshDeployedState : records the latest build of an environment that
+// completed successfully, skipping syncs whose drift was rejected, as its
+// deployed state
+func refreshDeployedState(db *gorm.DB, envID uint) (uint, error) {
+	var builds []Build
+	var id uint
+
+	err := db.Select("id").
+		Where("environment_id = ? AND status = ? AND type in (?)", envID, "done", StateActions).
+		Where(notRejectedSync).
+		Order("created_at desc").
+		Limit(1).
+		Find(&builds).
+		Error
+
+	if err != nil {
+		return 0, err
+	}
+
+	if len(builds) > 0 {
+		id = builds[0].ID
+	}
+
+	err = db.Exec(`INSERT INTO deployed_states (environment_id, build_id, updated_at) VALUES (?, ?, now())
+		ON CONFLICT (environment_id) DO UPDATE SET build_id = excluded.build_id, updated_at = excluded.updated_at
+		WHERE deployed_states.build_id != excluded.build_id`, envID, id).Error
+
+	return id, err
+}
@@ -0,0 +1,144 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Drift : the differences a sync build found between the stored and the real environment
+type Drift struct {
+	Added      []map[string]interface{} `json:"added"`
+	Removed    []map[string]interface{} `json:"removed"`
+	Changed    []ComponentChange        `json:"changed"`
+	Resolution string                   `json:"resolution,omitempty"`
+	ResolvedAt *time.Time               `json:"resolved_at,omitempty"`
+}
+
+// ComponentChange : a component whose fields differ
+type ComponentChange struct {
+	ID     string        `json:"_component_id"`
+	Fields []FieldChange `json:"fields"`
+}
+
+// FieldChange : a single field difference of a component
+type FieldChange struct {
+	Path string      `json:"path"`
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// Validate : checks that the drift report references its components
+func (d *Drift) Validate() error {
+	for _, c := range append(d.Added, d.Removed...) {
+		if id, _ := c["_component_id"].(string); id == "" {
+			return errors.New("drift component has no _component_id")
+		}
+	}
+
+	for _, c := range d.Changed {
+		if c.ID == "" {
+			return errors.New("drift change has no _component_id")
+		}
+		if len(c.Fields) < 1 {
+			return errors.New("drift change " + c.ID + " has no changed fields")
+		}
+	}
+
+	return nil
+}
+
+// Map : converts the drift report to its stored representation
+func (d *Drift) Map() (Map, error) {
+	var m Map
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &m)
+
+	return m, err
+}
+
+// GetDrift : returns the drift report stored on the build
+func (b *Build) GetDrift() (*Drift, error) {
+	var d Drift
+
+	data, err := json.Marshal(b.Drift)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &d)
+
+	return &d, err
+}
+
+// SetDrift : stores a drift report on a sync build
+func (b *Build) SetDrift(d *Drift) error {
+	if b.Type != "sync" {
+		return errors.New("drift can only be set on sync builds")
+	}
+
+	err := d.Validate()
+	if err != nil {
+		return err
+	}
+
+	b.Drift, err = d.Map()
+	if err != nil {
+		return err
+	}
+
+	return b.Update()
+}
+
+// SetLatestDriftResolution : records how the drift of the latest sync build
+// was resolved, on the given transaction
+func SetLatestDriftResolution(db *gorm.DB, envID uint, action string) error {
+	pb, err := latestBuild(db, envID)
+	if err != nil {
+		return err
+	}
+
+	if pb.Type != "sync" {
+		return nil
+	}
+
+	d, err := pb.GetDrift()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	d.Resolution = strings.TrimPrefix(action, "sync-")
+	d.ResolvedAt = &now
+
+	pb.Drift, err = d.Map()
+	if err != nil {
+		return err
+	}
+
+	err = db.Model(pb).UpdateColumns(map[string]interface{}{
+		"drift":      pb.Drift,
+		"updated_at": time.Now(),
+	}).Error
+
+	if err != nil {
+		return err
+	}
+
+	// a rejected sync is no longer what is deployed
+	_, err = refreshDeployedState(db, envID)
+
+	return err
+}
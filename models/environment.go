@@ -5,11 +5,10 @@
 package models
 
 import (
-	"os"
 	"reflect"
 	"time"
 
-	aes "github.com/ernestio/crypto/aes"
+	"github.com/jinzhu/gorm"
 )
 
 // EnvironmentFields ...
@@ -20,18 +19,25 @@ var EnvironmentQueryFields = []string{"ids->id", "names->name"}
 
 // Environment : the database mapped entity
 type Environment struct {
-	ID          uint       `json:"id" gorm:"primary_key"`
-	ProjectID   uint       `json:"project_id"`
-	Name        string     `json:"name" gorm:"type:varchar(100);unique_index"`
-	Type        string     `json:"type"`
-	Status      string     `json:"status"`
-	Options     Map        `json:"options" gorm:"type: jsonb not null default '{}'::jsonb"`
-	Schedules   Map        `json:"schedules" gorm:"type: jsonb not null default '{}'::jsonb"`
-	Credentials Map        `json:"credentials" gorm:"type: jsonb not null default '{}'::jsonb"`
-	Builds      []Build    `json:"builds" sql:"-"`
-	CreatedAt   time.Time  `json:"created_at"`
-	UpdatedAt   time.Time  `json:"updated_at"`
-	DeletedAt   *time.Time `json:"-" sql:"index"`
+	ID              uint       `json:"id" gorm:"primary_key"`
+	ProjectID       uint       `json:"project_id" gorm:"unique_index:idx_environments_project_name"`
+	Name            string     `json:"name" gorm:"type:varchar(100);unique_index:idx_environments_project_name"`
+	Type            string     `json:"type"`
+	Status          string     `json:"status"`
+	Options         Map        `json:"options" gorm:"type: jsonb not null default '{}'::jsonb"`
+	Schedules       Map        `json:"schedules" gorm:"type: jsonb not null default '{}'::jsonb"`
+	Credentials     Map        `json:"credentials" gorm:"type: jsonb not null default '{}'::jsonb"`
+	CredentialSetID uint       `json:"credential_set_id,omitempty" gorm:"index"`
+	DataKey         string     `json:"-"`
+	LockedBy        string     `json:"locked_by,omitempty"`
+	LockReason      string     `json:"lock_reason,omitempty"`
+	LockedUntil     *time.Time `json:"locked_until,omitempty"`
+	Builds          []Build    `json:"builds" sql:"-"`
+	Scope           *Scope     `json:"_scope,omitempty" gorm:"-" sql:"-"`
+	Actor           string     `json:"-" gorm:"-" sql:"-"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	DeletedAt       *time.Time `json:"-" sql:"index"`
 }
 
 // TableName : set Entity's table name to be environments
@@ -43,21 +49,48 @@ func (e *Environment) TableName() string {
 func FindEnvironments(q map[string]interface{}) ([]Environment, error) {
 	var environments []Environment
 	err := query(q, EnvironmentFields, EnvironmentQueryFields).Order("updated_at desc").Find(&environments).Error
-	return environments, err
+	if err != nil {
+		return nil, err
+	}
+	for i := range environments {
+		environments[i].clearExpiredLock()
+	}
+	return environments, resolveCredentialSets(environments)
 }
 
-// GetEnvironment ....
+// GetEnvironment : gets an environment. Environments are only named uniquely
+// within a project, so a name that more than one project uses also needs the
+// project_id
 func GetEnvironment(q map[string]interface{}) (*Environment, error) {
-	var environment Environment
+	var environments []Environment
 
 	err := query(q, EnvironmentFields, EnvironmentQueryFields).
-		First(&environment).
+		Order("id").
+		Limit(2).
+		Find(&environments).
 		Error
 
 	if err != nil {
 		return nil, err
 	}
 
+	if len(environments) < 1 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	if len(environments) > 1 && q["name"] != nil && q["id"] == nil && q["project_id"] == nil {
+		return nil, &AmbiguousNameError{Name: environments[0].Name}
+	}
+
+	environment := environments[0]
+
+	environment.clearExpiredLock()
+
+	err = environment.resolveCredentialSet()
+	if err != nil {
+		return nil, err
+	}
+
 	err = query(
 		map[string]interface{}{"environment_id": environment.ID}, BuildFields, []string{}).
 		Select(BuildMinimalFields).
@@ -74,12 +107,36 @@ func GetEnvironment(q map[string]interface{}) (*Environment, error) {
 
 // Create ...
 func (e *Environment) Create() error {
-	ec, err := encryptCredentials(e.Credentials)
+	e.DataKey = ""
+
+	err := e.Scope.Check(e.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	err = checkCredentialSet(e)
+	if err != nil {
+		return err
+	}
+
+	if e.CredentialSetID != 0 {
+		e.Credentials = Map{}
+	}
+
+	ec, err := encryptCredentials(e, nil, e.Credentials)
+	if err != nil {
+		return err
+	}
+
+	err = ValidateCredentials(e.Type, ec)
 	if err != nil {
 		return err
 	}
 
 	e.Credentials = ec
+	e.LockedBy = ""
+	e.LockReason = ""
+	e.LockedUntil = nil
 
 	return DB.Create(e).Error
 }
@@ -97,11 +154,35 @@ func (e *Environment) HasChangedSchedules() bool {
 	return !reflect.DeepEqual(stored.Schedules, e.Schedules)
 }
 
-// Update ...
+// Update : updates the stored environment while it is locked, as long as it
+// isn't locked by someone other than the environment's actor
 func (e *Environment) Update() error {
+	var err error
 	var stored Environment
+	var ec Map
 
-	err := DB.Where("id = ?", e.ID).First(&stored).Error
+	tx := DB.Begin()
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit().Error
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	err = tx.Raw("SELECT * FROM environments WHERE id = ? for update", e.ID).Scan(&stored).Error
+	if err != nil {
+		return err
+	}
+
+	err = e.Scope.Check(stored.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	err = stored.checkLock(e.Actor)
 	if err != nil {
 		return err
 	}
@@ -112,8 +193,26 @@ func (e *Environment) Update() error {
 
 	stored.Schedules = e.Schedules
 
-	if e.Credentials != nil {
-		ec, err := encryptCredentials(e.Credentials)
+	switch {
+	case e.CredentialSetID != 0:
+		stored.CredentialSetID = e.CredentialSetID
+
+		err = checkCredentialSet(&stored)
+		if err != nil {
+			return err
+		}
+
+		stored.Credentials = Map{}
+		stored.DataKey = ""
+	case e.Credentials != nil:
+		stored.CredentialSetID = 0
+
+		ec, err = encryptCredentials(&stored, stored.Credentials, e.Credentials)
+		if err != nil {
+			return err
+		}
+
+		err = ValidateCredentials(stored.Type, ec)
 		if err != nil {
 			return err
 		}
@@ -121,18 +220,41 @@ func (e *Environment) Update() error {
 		stored.Credentials = ec
 	}
 
-	return DB.Save(&stored).Error
+	err = tx.Save(&stored).Error
+
+	return err
 }
 
-// Delete ...
+// Delete : deletes the environment and its builds. The environment is
+// loaded first, so callers can clean up what refers to it by its project
+// and name
 func (e *Environment) Delete() error {
 	var err error
+	var stored *Environment
 
 	if e.ID == 0 {
-		err = DB.Where("name = ?", e.Name).First(e).Error
-		if err != nil {
-			return err
-		}
+		stored, err = findEnvironmentByName(e.Name, e.ProjectID)
+	} else {
+		stored = &Environment{}
+		err = DB.Where("id = ?", e.ID).First(stored).Error
+	}
+
+	if err != nil {
+		return err
+	}
+
+	scope := e.Scope
+	*e = *stored
+	e.Scope = scope
+
+	err = e.Scope.CheckEnvironment(DB, e.ID)
+	if err != nil {
+		return err
+	}
+
+	err = DB.Where("build_id in (SELECT id FROM builds WHERE environment_id = ?)", e.ID).Delete(BuildComponent{}).Error
+	if err != nil {
+		return err
 	}
 
 	err = DB.Unscoped().Where("environment_id = ?", e.ID).Delete(Build{}).Error
@@ -140,9 +262,54 @@ func (e *Environment) Delete() error {
 		return err
 	}
 
+	err = DB.Where("environment_id = ?", e.ID).Delete(DeployedState{}).Error
+	if err != nil {
+		return err
+	}
+
 	return DB.Unscoped().Delete(e).Error
 }
 
+// AmbiguousNameError : an environment looked up by a name that more than
+// one project uses
+type AmbiguousNameError struct {
+	Name string
+}
+
+func (e *AmbiguousNameError) Error() string {
+	return "environment name " + e.Name + " is used by more than one project, a project_id is required"
+}
+
+// Code ...
+func (e *AmbiguousNameError) Code() string {
+	return "ambiguous_name"
+}
+
+// findEnvironmentByName : finds an environment by name within a project, or
+// by name alone if only one project uses it
+func findEnvironmentByName(name string, projectID uint) (*Environment, error) {
+	var environments []Environment
+
+	db := DB.Where("name = ?", name)
+	if projectID != 0 {
+		db = db.Where("project_id = ?", projectID)
+	}
+
+	err := db.Order("id").Limit(2).Find(&environments).Error
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(environments) {
+	case 0:
+		return nil, gorm.ErrRecordNotFound
+	case 1:
+		return &environments[0], nil
+	}
+
+	return nil, &AmbiguousNameError{Name: name}
+}
+
 // GetState ...
 func (e *Environment) GetState() string {
 	return e.Status
@@ -172,38 +339,15 @@ func (e *Environment) UnsetSchedule(name string) {
 	delete(e.Schedules, name)
 }
 
-func crypt(s string) (string, error) {
-	crypto := aes.New()
-	key := os.Getenv("ERNEST_CRYPTO_KEY")
-	if s != "" {
-		encrypted, err := crypto.Encrypt(s, key)
-		if err != nil {
-			return "", err
-		}
-		s = encrypted
+// encryptCredentials : merges the credentials into the stored ones,
+// encrypting new secret values
+func encryptCredentials(e *Environment, stored, c Map) (Map, error) {
+	p, err := CredentialProvider()
+	if err != nil {
+		return c, err
 	}
 
-	return s, nil
-}
-
-func encryptCredentials(c Map) (Map, error) {
-	for k, v := range c {
-		if k == "region" || k == "external_network" || k == "username" || k == "vcloud_url" {
-			continue
-		}
-
-		xc, ok := v.(string)
-		if !ok {
-			continue
-		}
-
-		x, err := crypt(xc)
-		if err != nil {
-			return c, err
-		}
-
-		c[k] = x
-	}
+	s, _ := GetCredentialSchema(e.Type)
 
-	return c, nil
+	return mergeCredentials(p, e, s, stored, c)
 }
@@ -0,0 +1,150 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultLockTTL : how long an environment stays locked when no ttl is given
+var DefaultLockTTL = time.Hour
+
+// EnvironmentLockedError : returned when an environment is locked by someone else
+type EnvironmentLockedError struct {
+	Name   string
+	Owner  string
+	Reason string
+	Until  time.Time
+}
+
+// Error ...
+func (e *EnvironmentLockedError) Error() string {
+	msg := "environment " + e.Name + " is locked by " + e.Owner + " until " + e.Until.Format(time.RFC3339)
+	if e.Reason != "" {
+		msg = msg + ": " + e.Reason
+	}
+	return msg
+}
+
+// Code : identifies the error type to callers
+func (e *EnvironmentLockedError) Code() string {
+	return "environment_locked"
+}
+
+// IsLocked : checks if the environment holds a lock that has not expired
+func (e *Environment) IsLocked() bool {
+	return e.LockedBy != "" && e.LockedUntil != nil && e.LockedUntil.After(time.Now())
+}
+
+// checkLock : returns an EnvironmentLockedError if the environment is locked
+// by someone other than the given owner
+func (e *Environment) checkLock(owner string) error {
+	if !e.IsLocked() || e.LockedBy == owner {
+		return nil
+	}
+
+	return &EnvironmentLockedError{
+		Name:   e.Name,
+		Owner:  e.LockedBy,
+		Reason: e.LockReason,
+		Until:  *e.LockedUntil,
+	}
+}
+
+// Lock : locks the environment for the owner until the ttl expires. An owner
+// can extend their own lock
+func (e *Environment) Lock(owner, reason string, ttl time.Duration) error {
+	if owner == "" {
+		return errors.New("an environment can only be locked by a signed request")
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultLockTTL
+	}
+
+	return e.updateLock(func(stored *Environment) error {
+		err := stored.checkLock(owner)
+		if err != nil {
+			return err
+		}
+
+		until := time.Now().Add(ttl)
+
+		stored.LockedBy = owner
+		stored.LockReason = reason
+		stored.LockedUntil = &until
+
+		return nil
+	})
+}
+
+// Unlock : releases the environment's lock. Only the owner can release
+// a lock unless it is forced
+func (e *Environment) Unlock(owner string, force bool) error {
+	return e.updateLock(func(stored *Environment) error {
+		if !force {
+			err := stored.checkLock(owner)
+			if err != nil {
+				return err
+			}
+		}
+
+		stored.LockedBy = ""
+		stored.LockReason = ""
+		stored.LockedUntil = nil
+
+		return nil
+	})
+}
+
+func (e *Environment) updateLock(fn func(stored *Environment) error) error {
+	var err error
+	var stored Environment
+
+	tx := DB.Begin()
+	tx.Exec("set transaction isolation level serializable")
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit().Error
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	err = tx.Raw("SELECT * FROM environments WHERE id = ? for update", e.ID).Scan(&stored).Error
+	if err != nil {
+		return err
+	}
+
+	err = fn(&stored)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Exec("UPDATE environments SET locked_by = ?, lock_reason = ?, locked_until = ? WHERE id = ?", stored.LockedBy, stored.LockReason, stored.LockedUntil, stored.ID).Error
+	if err != nil {
+		return err
+	}
+
+	e.LockedBy = stored.LockedBy
+	e.LockReason = stored.LockReason
+	e.LockedUntil = stored.LockedUntil
+
+	return nil
+}
+
+// clearExpiredLock : hides a lock whose ttl has passed
+func (e *Environment) clearExpiredLock() {
+	if e.IsLocked() {
+		return
+	}
+
+	e.LockedBy = ""
+	e.LockReason = ""
+	e.LockedUntil = nil
+}
@@ -16,11 +16,18 @@ type StatePayload struct {
 	EnvironmentID uint
 	Action        string
 	tx            *gorm.DB
+	changed       []*Build
 }
 
 var (
 	// BaseStates : base environment states
 	BaseStates = []string{"initializing", "done", "errored", "in_progress", "awaiting_approval", "awaiting_resolution"}
+
+	// RunningStates : states of an environment with a build running against it
+	RunningStates = []string{"in_progress", "syncing"}
+
+	// RunningActions : build actions that start running a build
+	RunningActions = []string{"apply", "destroy", "import", "sync", "sync-rejected", "submission-accepted"}
 )
 
 // NewStateMachine ...
@@ -61,7 +68,20 @@ func CallbackUpdateStatus(state string, p interface{}) error {
 
 	switch sp.Action {
 	case "sync-accepted", "sync-ignored", "sync-rejected", "submission-accepted", "submission-rejected":
-		err = SetLatestBuildStatus(sp.EnvironmentID, "done")
+		var b *Build
+		b, err = SetLatestBuildStatus(sp.tx, sp.EnvironmentID, "done")
+		if err == nil {
+			sp.changed = append(sp.changed, b)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	switch sp.Action {
+	case "sync-accepted", "sync-ignored", "sync-rejected":
+		err = SetLatestDriftResolution(sp.tx, sp.EnvironmentID, sp.Action)
 	}
 
 	if err != nil {
@@ -0,0 +1,163 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// ExpiryWindows : how long an environment can wait for a decision before it is expired
+type ExpiryWindows struct {
+	Approval   time.Duration
+	Resolution time.Duration
+}
+
+// Expiry : details of a pending build that has been expired
+type Expiry struct {
+	ID            string `json:"id"`
+	EnvironmentID uint   `json:"environment_id"`
+	Name          string `json:"name"`
+	Action        string `json:"action"`
+	Reason        string `json:"reason"`
+}
+
+// ExpiryWindowsFromEnv : loads the expiry windows from ERNEST_APPROVAL_EXPIRY and
+// ERNEST_RESOLUTION_EXPIRY. An unset or invalid window disables expiry
+func ExpiryWindowsFromEnv() ExpiryWindows {
+	return ExpiryWindows{
+		Approval:   parseWindow(os.Getenv("ERNEST_APPROVAL_EXPIRY")),
+		Resolution: parseWindow(os.Getenv("ERNEST_RESOLUTION_EXPIRY")),
+	}
+}
+
+// ExpirePending : rejects submissions and ignores syncs that have been waiting
+// longer than their expiry window
+func ExpirePending(w ExpiryWindows) ([]Expiry, error) {
+	var envs []Environment
+	var expired []Expiry
+
+	err := DB.Where("status in (?)", []string{"awaiting_approval", "awaiting_resolution"}).Find(&envs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, env := range envs {
+		action, window := env.expiryWindow(w)
+		if window <= 0 {
+			continue
+		}
+
+		b, err := GetLatestBuild(env.ID)
+		if err != nil {
+			log.Println("could not get latest build of " + env.Name + ": " + err.Error())
+			continue
+		}
+
+		if time.Since(b.UpdatedAt) < window {
+			continue
+		}
+
+		reason := "expired after waiting " + window.String() + " for a decision"
+
+		err = expireEnvironment(env.ID, b.ID, action, reason)
+		if err != nil {
+			log.Println("could not expire environment " + env.Name + ": " + err.Error())
+			continue
+		}
+
+		expired = append(expired, Expiry{
+			ID:            b.UUID,
+			EnvironmentID: env.ID,
+			Name:          env.Name,
+			Action:        action,
+			Reason:        reason,
+		})
+	}
+
+	return expired, nil
+}
+
+// expiryWindow : returns the action and window that applies to the environment.
+// Windows set on the environment's options take precedence over the defaults
+func (e *Environment) expiryWindow(w ExpiryWindows) (string, time.Duration) {
+	switch e.Status {
+	case "awaiting_approval":
+		return "submission-rejected", optionWindow(e.Options, "approval_expiry", w.Approval)
+	case "awaiting_resolution":
+		return "sync-ignored", optionWindow(e.Options, "resolution_expiry", w.Resolution)
+	}
+
+	return "", 0
+}
+
+func expireEnvironment(envID, buildID uint, action, reason string) error {
+	var err error
+	var env Environment
+
+	tx := DB.Begin()
+	tx.Exec("set transaction isolation level serializable")
+
+	p := StatePayload{
+		EnvironmentID: envID,
+		Action:        action,
+		tx:            tx,
+	}
+
+	defer func() {
+		statusChanged(err, p.changed...)
+	}()
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit().Error
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	err = tx.Raw("SELECT * FROM environments WHERE id = ? for update", envID).Scan(&env).Error
+	if err != nil {
+		return err
+	}
+
+	// the state machine will refuse the transition if a decision was made in
+	// the meantime. The build's status and drift are set on this transaction,
+	// as writing them on another would fail to serialize with the reason
+	sm := NewStateMachine(&env)
+	err = sm.Trigger(action, &p)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Exec("UPDATE builds SET reason = ? WHERE id = ?", reason, buildID).Error
+
+	return err
+}
+
+func optionWindow(opts Map, key string, def time.Duration) time.Duration {
+	v, ok := opts[key].(string)
+	if !ok {
+		return def
+	}
+
+	return parseWindow(v)
+}
+
+func parseWindow(s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		log.Println("invalid expiry window '" + s + "': " + err.Error())
+		return 0
+	}
+
+	return d
+}
@@ -0,0 +1,288 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// FreezeFields ...
+var FreezeFields = structFields(Freeze{})
+
+// FrozenActions : build actions that are blocked while a freeze is active
+var FrozenActions = RunningActions
+
+// MaxFreezeHorizon : how far ahead the next window of a freeze can be looked
+// for, as recurring freezes are checked a minute at a time
+const MaxFreezeHorizon = time.Hour * 24 * 31
+
+// Freeze : a change freeze that blocks builds on an environment or on all
+// environments of a project. A freeze is either a one-off range between
+// starts_at and ends_at, or a recurring window that opens on a cron schedule
+// and lasts for the given duration
+type Freeze struct {
+	ID            uint       `json:"id" gorm:"primary_key"`
+	Name          string     `json:"name"`
+	ProjectID     uint       `json:"project_id" gorm:"index"`
+	EnvironmentID uint       `json:"environment_id" gorm:"index"`
+	Reason        string     `json:"reason"`
+	StartsAt      *time.Time `json:"starts_at"`
+	EndsAt        *time.Time `json:"ends_at"`
+	Schedule      string     `json:"schedule"`
+	Duration      string     `json:"duration"`
+	Timezone      string     `json:"timezone"`
+	Exempt        List       `json:"exempt" gorm:"type: jsonb not null default '[]'::jsonb"`
+	Scope         *Scope     `json:"_scope,omitempty" gorm:"-" sql:"-"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	DeletedAt     *time.Time `json:"-" sql:"index"`
+}
+
+// Window : a period of time covered by a freeze
+type Window struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// TableName : set Entity's table name to be freezes
+func (f *Freeze) TableName() string {
+	return "freezes"
+}
+
+// FindFreezes : finds freezes
+func FindFreezes(q map[string]interface{}) ([]Freeze, error) {
+	var freezes []Freeze
+	err := query(q, FreezeFields, []string{}).Order("created_at desc").Find(&freezes).Error
+	return freezes, err
+}
+
+// GetFreeze : gets a freeze
+func GetFreeze(q map[string]interface{}) (*Freeze, error) {
+	var freeze Freeze
+	err := query(q, FreezeFields, []string{}).First(&freeze).Error
+	return &freeze, err
+}
+
+// EnvironmentFreezes : gets the freezes that apply to an environment, including
+// the ones defined on its project
+func EnvironmentFreezes(e *Environment) ([]Freeze, error) {
+	return environmentFreezes(DB, e)
+}
+
+func environmentFreezes(db *gorm.DB, e *Environment) ([]Freeze, error) {
+	var freezes []Freeze
+	err := db.Where("environment_id = ? OR (project_id = ? AND environment_id = 0)", e.ID, e.ProjectID).Find(&freezes).Error
+	return freezes, err
+}
+
+// Create ...
+func (f *Freeze) Create() error {
+	err := f.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = f.Scope.checkFreeze(f)
+	if err != nil {
+		return err
+	}
+
+	return DB.Create(f).Error
+}
+
+// Update ...
+func (f *Freeze) Update() error {
+	var stored Freeze
+
+	err := DB.Where("id = ?", f.ID).First(&stored).Error
+	if err != nil {
+		return err
+	}
+
+	err = f.Validate()
+	if err != nil {
+		return err
+	}
+
+	for _, x := range []*Freeze{&stored, f} {
+		err = f.Scope.checkFreeze(x)
+		if err != nil {
+			return err
+		}
+	}
+
+	f.CreatedAt = stored.CreatedAt
+
+	return DB.Save(f).Error
+}
+
+// Delete ...
+func (f *Freeze) Delete() error {
+	if f.Scope.Restricted() {
+		var stored Freeze
+
+		err := DB.Where("id = ?", f.ID).First(&stored).Error
+		if err != nil {
+			return err
+		}
+
+		err = f.Scope.checkFreeze(&stored)
+		if err != nil {
+			return err
+		}
+	}
+
+	return DB.Unscoped().Delete(f).Error
+}
+
+// Validate : checks the freeze is scoped and describes a valid window
+func (f *Freeze) Validate() error {
+	if f.ProjectID == 0 && f.EnvironmentID == 0 {
+		return errors.New("freeze must have a project_id or an environment_id")
+	}
+
+	_, err := f.location()
+	if err != nil {
+		return err
+	}
+
+	if f.Schedule == "" {
+		if f.StartsAt == nil || f.EndsAt == nil {
+			return errors.New("freeze must have a schedule or both starts_at and ends_at")
+		}
+		if !f.EndsAt.After(*f.StartsAt) {
+			return errors.New("freeze must end after it starts")
+		}
+		return nil
+	}
+
+	_, err = parseCron(f.Schedule)
+	if err != nil {
+		return err
+	}
+
+	d, err := time.ParseDuration(f.Duration)
+	if err != nil || d <= 0 {
+		return errors.New("recurring freeze must have a positive duration")
+	}
+
+	return nil
+}
+
+// Exempts : checks if the freeze allows a build action
+func (f *Freeze) Exempts(action string) bool {
+	if !List(FrozenActions).Contains(action) {
+		return true
+	}
+	return f.Exempt.Contains(action)
+}
+
+// ActiveAt : returns the window of the freeze covering the given time, if any
+func (f *Freeze) ActiveAt(t time.Time) (*Window, error) {
+	if f.Schedule == "" {
+		if f.StartsAt == nil || f.EndsAt == nil {
+			return nil, nil
+		}
+		if t.Before(*f.StartsAt) || !t.Before(*f.EndsAt) {
+			return nil, nil
+		}
+		return &Window{Start: *f.StartsAt, End: *f.EndsAt}, nil
+	}
+
+	c, d, loc, err := f.recurrence()
+	if err != nil {
+		return nil, err
+	}
+
+	t = t.In(loc)
+	start := t.Truncate(time.Minute)
+
+	// walk back over the freeze's duration looking for the most recent opening
+	for s := start; t.Sub(s) < d; s = s.Add(-time.Minute) {
+		if c.matches(s) {
+			return &Window{Start: s, End: s.Add(d)}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Next : returns the next window of the freeze starting after the given
+// time and within the horizon, if any
+func (f *Freeze) Next(t time.Time, horizon time.Duration) (*Window, error) {
+	if f.Schedule == "" {
+		if f.StartsAt == nil || f.EndsAt == nil || !f.StartsAt.After(t) || f.StartsAt.Sub(t) > horizon {
+			return nil, nil
+		}
+		return &Window{Start: *f.StartsAt, End: *f.EndsAt}, nil
+	}
+
+	c, d, loc, err := f.recurrence()
+	if err != nil {
+		return nil, err
+	}
+
+	t = t.In(loc)
+
+	for s := t.Truncate(time.Minute).Add(time.Minute); s.Sub(t) <= horizon; s = s.Add(time.Minute) {
+		if c.matches(s) {
+			return &Window{Start: s, End: s.Add(d)}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (f *Freeze) recurrence() (*cron, time.Duration, *time.Location, error) {
+	c, err := parseCron(f.Schedule)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	d, err := time.ParseDuration(f.Duration)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	loc, err := f.location()
+
+	return c, d, loc, err
+}
+
+func (f *Freeze) location() (*time.Location, error) {
+	if f.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(f.Timezone)
+}
+
+// checkFreezes : returns an error if a freeze that does not exempt the action
+// is active on the environment
+func checkFreezes(db *gorm.DB, e *Environment, action string, t time.Time) error {
+	freezes, err := environmentFreezes(db, e)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range freezes {
+		if f.Exempts(action) {
+			continue
+		}
+
+		w, err := f.ActiveAt(t)
+		if err != nil {
+			return err
+		}
+
+		if w != nil {
+			return errors.New("could not create environment build: environment is frozen by '" + f.Name + "' until " + w.End.Format(time.RFC3339))
+		}
+	}
+
+	return nil
+}
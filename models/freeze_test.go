@@ -0,0 +1,59 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFreezeWindows(t *testing.T) {
+	start := time.Date(2017, 12, 20, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		Name   string
+		Freeze Freeze
+		At     time.Time
+		Active bool
+		Next   *time.Time
+	}{
+		{"one-off-active", Freeze{StartsAt: &start, EndsAt: &end}, time.Date(2017, 12, 25, 12, 0, 0, 0, time.UTC), true, nil},
+		{"one-off-upcoming", Freeze{StartsAt: &start, EndsAt: &end}, time.Date(2017, 12, 19, 12, 0, 0, 0, time.UTC), false, &start},
+		{"one-off-ended", Freeze{StartsAt: &start, EndsAt: &end}, end, false, nil},
+		{"weekend-active", Freeze{Schedule: "0 18 * * 5", Duration: "62h"}, time.Date(2017, 12, 17, 10, 0, 0, 0, time.UTC), true, nil},
+		{"weekend-upcoming", Freeze{Schedule: "0 18 * * 5", Duration: "62h"}, time.Date(2017, 12, 20, 10, 0, 0, 0, time.UTC), false, timePtr(time.Date(2017, 12, 22, 18, 0, 0, 0, time.UTC))},
+		{"timezone", Freeze{Schedule: "0 9 * * *", Duration: "1h", Timezone: "America/New_York"}, time.Date(2017, 12, 20, 14, 30, 0, 0, time.UTC), true, nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			w, err := tc.Freeze.ActiveAt(tc.At)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.Active, w != nil)
+
+			if tc.Next != nil {
+				n, err := tc.Freeze.Next(tc.At, time.Hour*24*7)
+				assert.Nil(t, err)
+				assert.NotNil(t, n)
+				assert.True(t, tc.Next.Equal(n.Start))
+			}
+		})
+	}
+}
+
+func TestFreezeValidate(t *testing.T) {
+	assert.NotNil(t, (&Freeze{Schedule: "0 18 * * 5", Duration: "1h"}).Validate())
+	assert.NotNil(t, (&Freeze{ProjectID: 1, Schedule: "0 18 * *", Duration: "1h"}).Validate())
+	assert.NotNil(t, (&Freeze{ProjectID: 1, Schedule: "0 18 * * 5"}).Validate())
+	assert.NotNil(t, (&Freeze{ProjectID: 1, Schedule: "0 18 * * 5", Duration: "1h", Timezone: "Nowhere/Special"}).Validate())
+	assert.Nil(t, (&Freeze{ProjectID: 1, Schedule: "*/15 9-17 * * 1-5", Duration: "5m", Exempt: List{"sync"}}).Validate())
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,122 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"encoding/json"
+)
+
+// InventoryQuery : the components to find across environments, optionally
+// within a project
+type InventoryQuery struct {
+	ComponentFilter
+	ProjectID uint   `json:"project_id"`
+	Scope     *Scope `json:"_scope"`
+}
+
+// InventoryItem : a reference to a component deployed in an environment
+type InventoryItem struct {
+	ProjectID       uint   `json:"project_id"`
+	EnvironmentID   uint   `json:"environment_id"`
+	EnvironmentName string `json:"environment_name"`
+	BuildID         string `json:"build_id"`
+	ComponentID     string `json:"component_id"`
+	Type            string `json:"type"`
+	Name            string `json:"name"`
+}
+
+// deployedBuilds : the build whose mapping is deployed in each environment,
+// found the same way as its recorded deployed state
+const deployedBuilds = `id in (
+	SELECT DISTINCT ON (environment_id) id FROM builds
+	WHERE status = 'done' AND type in (?) AND ` + notRejectedSync + ` AND deleted_at IS NULL
+	ORDER BY environment_id, created_at desc
+)`
+
+// FindInventory : finds the components matching the query in the mapping
+// deployed in every environment
+func FindInventory(q InventoryQuery) ([]InventoryItem, error) {
+	var builds []Build
+	var envs []Environment
+
+	items := []InventoryItem{}
+
+	db := q.Scope.apply(DB, BuildFields).
+		Select("id, uuid, environment_id, mapping").
+		Where(deployedBuilds, StateActions).
+		Where("environment_id in (SELECT id FROM environments WHERE deleted_at IS NULL)")
+
+	if q.ProjectID != 0 {
+		db = db.Where("environment_id in (SELECT id FROM environments WHERE project_id = ?)", q.ProjectID)
+	}
+
+	// only load the mappings that have a component of the type, provider
+	// and state being searched for
+	for field, v := range map[string]string{"_component": q.Type, "_provider": q.Provider, "_state": q.State} {
+		if v == "" {
+			continue
+		}
+
+		c, err := json.Marshal(map[string]string{field: v})
+		if err != nil {
+			return nil, err
+		}
+
+		db = db.Where(`(mapping->'components' @> ?::jsonb OR id in (
+			SELECT build_id FROM build_components WHERE kind = ? AND data @> ?::jsonb
+		))`, "["+string(c)+"]", ComponentKind, string(c))
+	}
+
+	err := db.Order("environment_id").Find(&builds).Error
+	if err != nil || len(builds) < 1 {
+		return items, err
+	}
+
+	err = loadMappings(builds)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint
+	for _, b := range builds {
+		ids = append(ids, b.EnvironmentID)
+	}
+
+	err = DB.Select("id, name, project_id").Where("id in (?)", ids).Find(&envs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint]Environment)
+	for _, e := range envs {
+		byID[e.ID] = e
+	}
+
+	for _, b := range builds {
+		components, err := b.Mapping.FindComponents(q.ComponentFilter)
+		if err != nil {
+			return nil, err
+		}
+
+		e := byID[b.EnvironmentID]
+
+		for _, c := range components {
+			item := InventoryItem{
+				ProjectID:       e.ProjectID,
+				EnvironmentID:   b.EnvironmentID,
+				EnvironmentName: e.Name,
+				BuildID:         b.UUID,
+			}
+
+			item.ComponentID, _ = c["_component_id"].(string)
+			item.Type, _ = c["_component"].(string)
+			item.Name, _ = c["name"].(string)
+
+			items = append(items, item)
+		}
+	}
+
+	return items, nil
+}
@@ -0,0 +1,373 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Patch : an RFC 6902 JSON patch, given as a list of operations, or an RFC
+// 7396 merge patch, given as an object
+type Patch struct {
+	Operations []PatchOperation
+	Merge      map[string]interface{}
+}
+
+// PatchOperation : an operation of a JSON patch. Its value is kept as it was
+// sent, so a null value can be told apart from a missing one
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// PatchError : a patch that can't be applied to a document
+type PatchError struct {
+	Reason string
+}
+
+func (e *PatchError) Error() string {
+	return "invalid patch: " + e.Reason
+}
+
+// Code ...
+func (e *PatchError) Code() string {
+	return "invalid_patch"
+}
+
+// PatchTestError : a test operation whose value didn't match the document.
+// Callers use them to only change documents that haven't changed since they
+// read them
+type PatchTestError struct {
+	Path string
+}
+
+func (e *PatchTestError) Error() string {
+	return "patch test failed: value at " + e.Path + " has changed"
+}
+
+// Code ...
+func (e *PatchTestError) Code() string {
+	return "conflict"
+}
+
+// UnmarshalJSON : loads a list of operations as a JSON patch and an object
+// as a merge patch
+func (p *Patch) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+
+	switch {
+	case len(data) > 0 && data[0] == '[':
+		return json.Unmarshal(data, &p.Operations)
+	case len(data) > 0 && data[0] == '{':
+		return json.Unmarshal(data, &p.Merge)
+	}
+
+	return &PatchError{"a patch must be a list of operations or an object"}
+}
+
+// Empty : checks if the patch has no changes
+func (p *Patch) Empty() bool {
+	return p.Operations == nil && p.Merge == nil
+}
+
+// Apply : applies the patch to a copy of a document, returning the copy
+func (p *Patch) Apply(doc interface{}) (interface{}, error) {
+	doc, err := copyValue(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Merge != nil {
+		return MergePatch(doc, p.Merge), nil
+	}
+
+	for _, op := range p.Operations {
+		doc, err = op.apply(doc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+// MergePatch : applies an RFC 7396 merge patch to a document. Null values
+// remove fields, objects are merged and any other value replaces the field
+func MergePatch(doc, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	merged := make(map[string]interface{})
+
+	if d, ok := doc.(map[string]interface{}); ok {
+		for k, v := range d {
+			merged[k] = v
+		}
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = MergePatch(merged[k], v)
+	}
+
+	return merged
+}
+
+func (op PatchOperation) apply(doc interface{}) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, &PatchError{op.Op + " operation at " + op.Path + " has no value"}
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return add(doc, path, op.value())
+	case "remove":
+		return remove(doc, path)
+	case "replace":
+		if len(path) < 1 {
+			return op.value(), nil
+		}
+		return update(doc, path, func(parent interface{}, key string) (interface{}, error) {
+			if _, err := get(parent, key); err != nil {
+				return nil, err
+			}
+			return put(parent, key, op.value(), false)
+		})
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := valueAt(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if op.Op == "copy" {
+			v, err = copyValue(v)
+			if err != nil {
+				return nil, err
+			}
+			return add(doc, path, v)
+		}
+
+		if op.From == op.Path {
+			return doc, nil
+		}
+
+		if strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, &PatchError{"can't move " + op.From + " into one of its children"}
+		}
+
+		doc, err = remove(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		return add(doc, path, v)
+	case "test":
+		v, err := valueAt(doc, path)
+		if err != nil || !reflect.DeepEqual(v, op.value()) {
+			return nil, &PatchTestError{Path: op.Path}
+		}
+		return doc, nil
+	}
+
+	return nil, &PatchError{"unknown operation " + op.Op}
+}
+
+func (op PatchOperation) value() interface{} {
+	var v interface{}
+	_ = json.Unmarshal(op.Value, &v)
+	return v
+}
+
+// parsePointer : splits an RFC 6901 JSON pointer into its unescaped tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, &PatchError{"path " + pointer + " must start with /"}
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+
+	return tokens, nil
+}
+
+func add(doc interface{}, path []string, v interface{}) (interface{}, error) {
+	if len(path) < 1 {
+		return v, nil
+	}
+
+	return update(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		return put(parent, key, v, true)
+	})
+}
+
+func remove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) < 1 {
+		return nil, &PatchError{"can't remove the whole document"}
+	}
+
+	return update(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := p[key]; !ok {
+				return nil, &PatchError{"field " + key + " doesn't exist"}
+			}
+			delete(p, key)
+			return p, nil
+		case []interface{}:
+			i, err := index(key, len(p)-1)
+			if err != nil {
+				return nil, err
+			}
+			return append(p[:i], p[i+1:]...), nil
+		}
+		return nil, &PatchError{"can't remove " + key + " from a value that isn't an object or a list"}
+	})
+}
+
+// update : replaces the parent of the last token of a path with the result
+// of a function. Lists have to be replaced in their own parent when items
+// are added to or removed from them
+func update(doc interface{}, path []string, f func(parent interface{}, key string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return f(doc, path[0])
+	}
+
+	child, err := get(doc, path[0])
+	if err != nil {
+		return nil, err
+	}
+
+	child, err = update(child, path[1:], f)
+	if err != nil {
+		return nil, err
+	}
+
+	return put(doc, path[0], child, false)
+}
+
+func valueAt(doc interface{}, path []string) (interface{}, error) {
+	var err error
+
+	for _, key := range path {
+		doc, err = get(doc, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+func get(doc interface{}, key string) (interface{}, error) {
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		v, ok := d[key]
+		if !ok {
+			return nil, &PatchError{"field " + key + " doesn't exist"}
+		}
+		return v, nil
+	case []interface{}:
+		i, err := index(key, len(d)-1)
+		if err != nil {
+			return nil, err
+		}
+		return d[i], nil
+	}
+
+	return nil, &PatchError{"can't get " + key + " from a value that isn't an object or a list"}
+}
+
+// put : sets a field of an object or an item of a list. Items are inserted
+// when adding, and "-" adds them to the end of the list
+func put(doc interface{}, key string, v interface{}, insert bool) (interface{}, error) {
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		d[key] = v
+		return d, nil
+	case []interface{}:
+		if !insert {
+			i, err := index(key, len(d)-1)
+			if err != nil {
+				return nil, err
+			}
+			d[i] = v
+			return d, nil
+		}
+
+		if key == "-" {
+			return append(d, v), nil
+		}
+
+		i, err := index(key, len(d))
+		if err != nil {
+			return nil, err
+		}
+
+		d = append(d, nil)
+		copy(d[i+1:], d[i:])
+		d[i] = v
+
+		return d, nil
+	}
+
+	return nil, &PatchError{"can't set " + key + " on a value that isn't an object or a list"}
+}
+
+// index : parses a list index, which can't be greater than max
+func index(key string, max int) (int, error) {
+	i, err := strconv.Atoi(key)
+	if err != nil || i < 0 || (len(key) > 1 && key[0] == '0') || key[0] == '+' {
+		return 0, &PatchError{"invalid list index " + key}
+	}
+
+	if i > max {
+		return 0, &PatchError{"list index " + key + " is out of range"}
+	}
+
+	return i, nil
+}
+
+func copyValue(v interface{}) (interface{}, error) {
+	var c interface{}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &c)
+
+	return c, err
+}
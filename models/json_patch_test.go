@@ -0,0 +1,75 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatchApply(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Doc      string
+		Patch    string
+		Expected string
+		Err      string
+	}{
+		{"add-field", `{"foo": "bar"}`, `[{"op": "add", "path": "/baz", "value": "qux"}]`, `{"foo": "bar", "baz": "qux"}`, ""},
+		{"add-item", `{"foo": ["bar", "baz"]}`, `[{"op": "add", "path": "/foo/1", "value": "qux"}]`, `{"foo": ["bar", "qux", "baz"]}`, ""},
+		{"add-to-end", `{"foo": ["bar"]}`, `[{"op": "add", "path": "/foo/-", "value": "qux"}]`, `{"foo": ["bar", "qux"]}`, ""},
+		{"add-null", `{"foo": "bar"}`, `[{"op": "add", "path": "/baz", "value": null}]`, `{"foo": "bar", "baz": null}`, ""},
+		{"add-without-value", `{"foo": "bar"}`, `[{"op": "add", "path": "/baz"}]`, "", "invalid_patch"},
+		{"add-out-of-range", `{"foo": ["bar"]}`, `[{"op": "add", "path": "/foo/2", "value": "qux"}]`, "", "invalid_patch"},
+		{"remove-field", `{"foo": "bar", "baz": "qux"}`, `[{"op": "remove", "path": "/baz"}]`, `{"foo": "bar"}`, ""},
+		{"remove-item", `{"foo": ["bar", "qux", "baz"]}`, `[{"op": "remove", "path": "/foo/1"}]`, `{"foo": ["bar", "baz"]}`, ""},
+		{"remove-missing", `{"foo": "bar"}`, `[{"op": "remove", "path": "/baz"}]`, "", "invalid_patch"},
+		{"replace", `{"foo": {"bar": "baz"}}`, `[{"op": "replace", "path": "/foo/bar", "value": 1}]`, `{"foo": {"bar": 1}}`, ""},
+		{"replace-missing", `{"foo": "bar"}`, `[{"op": "replace", "path": "/baz", "value": 1}]`, "", "invalid_patch"},
+		{"move", `{"foo": {"bar": "baz"}, "qux": {}}`, `[{"op": "move", "from": "/foo/bar", "path": "/qux/thud"}]`, `{"foo": {}, "qux": {"thud": "baz"}}`, ""},
+		{"move-item", `{"foo": ["all", "grass", "cows", "eat"]}`, `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`, `{"foo": ["all", "cows", "eat", "grass"]}`, ""},
+		{"move-into-child", `{"foo": {"bar": {}}}`, `[{"op": "move", "from": "/foo", "path": "/foo/bar/baz"}]`, "", "invalid_patch"},
+		{"copy", `{"foo": {"bar": "baz"}}`, `[{"op": "copy", "from": "/foo", "path": "/qux"}]`, `{"foo": {"bar": "baz"}, "qux": {"bar": "baz"}}`, ""},
+		{"escaped-path", `{"a/b": {"m~n": 1}}`, `[{"op": "replace", "path": "/a~1b/m~0n", "value": 2}]`, `{"a/b": {"m~n": 2}}`, ""},
+		{"test", `{"foo": {"bar": [1, 2]}}`, `[{"op": "test", "path": "/foo/bar", "value": [1, 2]}, {"op": "add", "path": "/baz", "value": true}]`, `{"foo": {"bar": [1, 2]}, "baz": true}`, ""},
+		{"test-failed", `{"foo": "bar"}`, `[{"op": "add", "path": "/baz", "value": true}, {"op": "test", "path": "/foo", "value": "qux"}]`, "", "conflict"},
+		{"unknown-op", `{"foo": "bar"}`, `[{"op": "append", "path": "/foo", "value": "qux"}]`, "", "invalid_patch"},
+		{"merge", `{"a": "b", "c": {"d": "e", "f": "g"}}`, `{"a": "z", "c": {"f": null}}`, `{"a": "z", "c": {"d": "e"}}`, ""},
+		{"merge-list", `{"a": [1, 2], "b": "c"}`, `{"a": [3], "d": {"e": "f"}}`, `{"a": [3], "b": "c", "d": {"e": "f"}}`, ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var doc, expected interface{}
+			var p Patch
+
+			assert.Nil(t, json.Unmarshal([]byte(tc.Doc), &doc))
+			assert.Nil(t, json.Unmarshal([]byte(tc.Patch), &p))
+
+			original, _ := copyValue(doc)
+
+			patched, err := p.Apply(doc)
+
+			// the document itself is left as it is
+			assert.Equal(t, original, doc)
+
+			if tc.Err != "" {
+				assert.NotNil(t, err)
+				assert.Equal(t, tc.Err, err.(coder).Code())
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Nil(t, json.Unmarshal([]byte(tc.Expected), &expected))
+			assert.Equal(t, expected, patched)
+		})
+	}
+}
+
+type coder interface {
+	Code() string
+}
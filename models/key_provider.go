@@ -0,0 +1,201 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+
+	aes "github.com/ernestio/crypto/aes"
+)
+
+// envelopePrefix : marks values encrypted with an environment's data key
+const envelopePrefix = "dk:"
+
+// KeyProvider : encrypts and decrypts the credential values of an environment
+type KeyProvider interface {
+	Encrypt(e *Environment, s string) (string, error)
+	Decrypt(e *Environment, s string) (string, error)
+	// IsCurrent : checks if a value is encrypted with the key new values would use
+	IsCurrent(e *Environment, s string) bool
+	// CurrentKey : identifies the key new values are encrypted with
+	CurrentKey() string
+}
+
+// KeyWrapper : wraps data keys with a master key. A KMS can be used as the
+// master key of the envelope provider by implementing it
+type KeyWrapper interface {
+	Wrap(key string) (string, error)
+	Unwrap(wrapped string) (string, error)
+	IsCurrent(wrapped string) bool
+	CurrentKey() string
+}
+
+// Rewrapper : a provider that stores key material on the environment that
+// needs rewrapping when its master key rotates
+type Rewrapper interface {
+	Rewrap(e *Environment) (bool, error)
+}
+
+// CredentialProvider : returns the provider selected by ERNEST_CRYPTO_PROVIDER.
+// "static" (the default) encrypts with the keys from the environment, "file"
+// with the keyring file at ERNEST_CRYPTO_KEYRING, and "envelope" encrypts
+// every environment with its own data key, wrapped by the keyring file if
+// ERNEST_CRYPTO_KEYRING is set or the keys from the environment otherwise
+func CredentialProvider() (KeyProvider, error) {
+	switch os.Getenv("ERNEST_CRYPTO_PROVIDER") {
+	case "", "static":
+		k := LoadKeyring()
+		return &KeyringProvider{Keyring: k}, k.Validate()
+	case "file":
+		k, err := LoadKeyringFile(os.Getenv("ERNEST_CRYPTO_KEYRING"))
+		if err != nil {
+			return nil, err
+		}
+		return &KeyringProvider{Keyring: k}, nil
+	case "envelope":
+		k := LoadKeyring()
+		if path := os.Getenv("ERNEST_CRYPTO_KEYRING"); path != "" {
+			var err error
+			k, err = LoadKeyringFile(path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return &EnvelopeProvider{Master: k, Fallback: &KeyringProvider{Keyring: k}}, k.Validate()
+	}
+
+	return nil, errors.New("unknown crypto provider '" + os.Getenv("ERNEST_CRYPTO_PROVIDER") + "'")
+}
+
+// KeyringProvider : encrypts the credentials of every environment with the
+// keyring's current key
+type KeyringProvider struct {
+	Keyring *Keyring
+}
+
+// Encrypt ...
+func (p *KeyringProvider) Encrypt(e *Environment, s string) (string, error) {
+	return p.Keyring.Encrypt(s)
+}
+
+// Decrypt ...
+func (p *KeyringProvider) Decrypt(e *Environment, s string) (string, error) {
+	return p.Keyring.Decrypt(s)
+}
+
+// IsCurrent ...
+func (p *KeyringProvider) IsCurrent(e *Environment, s string) bool {
+	return p.Keyring.IsCurrent(s)
+}
+
+// CurrentKey ...
+func (p *KeyringProvider) CurrentKey() string {
+	return p.Keyring.CurrentKey()
+}
+
+// EnvelopeProvider : encrypts the credentials of each environment with a data
+// key of its own, stored on the environment wrapped by the master key. Values
+// that aren't enveloped yet are decrypted with the fallback provider
+type EnvelopeProvider struct {
+	Master   KeyWrapper
+	Fallback KeyProvider
+}
+
+// Encrypt ...
+func (p *EnvelopeProvider) Encrypt(e *Environment, s string) (string, error) {
+	if s == "" {
+		return s, nil
+	}
+
+	dk, err := p.dataKey(e)
+	if err != nil {
+		return "", err
+	}
+
+	x, err := aes.New().Encrypt(s, dk)
+	if err != nil {
+		return "", err
+	}
+
+	return envelopePrefix + x, nil
+}
+
+// Decrypt ...
+func (p *EnvelopeProvider) Decrypt(e *Environment, s string) (string, error) {
+	if !strings.HasPrefix(s, envelopePrefix) {
+		return p.Fallback.Decrypt(e, s)
+	}
+
+	if e.DataKey == "" {
+		return "", errors.New("environment has no data key")
+	}
+
+	dk, err := p.Master.Unwrap(e.DataKey)
+	if err != nil {
+		return "", err
+	}
+
+	return aes.New().Decrypt(strings.TrimPrefix(s, envelopePrefix), dk)
+}
+
+// IsCurrent ...
+func (p *EnvelopeProvider) IsCurrent(e *Environment, s string) bool {
+	return strings.HasPrefix(s, envelopePrefix)
+}
+
+// CurrentKey ...
+func (p *EnvelopeProvider) CurrentKey() string {
+	return "envelope/" + p.Master.CurrentKey()
+}
+
+// Rewrap : wraps the environment's data key with the current master key
+func (p *EnvelopeProvider) Rewrap(e *Environment) (bool, error) {
+	if e.DataKey == "" || p.Master.IsCurrent(e.DataKey) {
+		return false, nil
+	}
+
+	dk, err := p.Master.Unwrap(e.DataKey)
+	if err != nil {
+		return false, err
+	}
+
+	wrapped, err := p.Master.Wrap(dk)
+	if err != nil {
+		return false, err
+	}
+
+	e.DataKey = wrapped
+
+	return true, nil
+}
+
+// dataKey : returns the environment's data key, generating one if it has none
+func (p *EnvelopeProvider) dataKey(e *Environment) (string, error) {
+	if e.DataKey != "" {
+		return p.Master.Unwrap(e.DataKey)
+	}
+
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	dk := hex.EncodeToString(b)
+
+	wrapped, err := p.Master.Wrap(dk)
+	if err != nil {
+		return "", err
+	}
+
+	e.DataKey = wrapped
+
+	return dk, nil
+}
@@ -0,0 +1,156 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	aes "github.com/ernestio/crypto/aes"
+)
+
+// Keyring : the keys used to encrypt and decrypt credentials.
+// Values are encrypted with the current key and prefixed with its id, as in
+// "<id>:<ciphertext>". Values without a prefix were encrypted before keys were
+// versioned and belong to the legacy key
+type Keyring struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+	Legacy  string            `json:"legacy"`
+}
+
+// LoadKeyring : loads the keyring from the environment.
+// ERNEST_CRYPTO_KEYS holds the decryption keys as a comma separated list of
+// "<id>=<key>" pairs and ERNEST_CRYPTO_KEY_ID names the key new values are
+// encrypted with. ERNEST_CRYPTO_KEY remains the legacy key, and is used
+// unversioned when no key id is set
+func LoadKeyring() *Keyring {
+	k := Keyring{
+		Current: os.Getenv("ERNEST_CRYPTO_KEY_ID"),
+		Keys:    make(map[string]string),
+		Legacy:  os.Getenv("ERNEST_CRYPTO_KEY"),
+	}
+
+	for _, pair := range strings.Split(os.Getenv("ERNEST_CRYPTO_KEYS"), ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		k.Keys[kv[0]] = kv[1]
+	}
+
+	return &k
+}
+
+// LoadKeyringFile : loads a keyring from a json file holding the "current"
+// key id, the "keys" by id and an optional "legacy" key
+func LoadKeyringFile(path string) (*Keyring, error) {
+	var k Keyring
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &k)
+	if err != nil {
+		return nil, err
+	}
+
+	if k.Keys == nil {
+		k.Keys = make(map[string]string)
+	}
+
+	return &k, k.Validate()
+}
+
+// Validate : checks that the current key can be used
+func (k *Keyring) Validate() error {
+	if k.Current == "" {
+		return nil
+	}
+
+	if _, ok := k.Keys[k.Current]; !ok {
+		return errors.New("current crypto key '" + k.Current + "' is not in the keyring")
+	}
+
+	return nil
+}
+
+// Encrypt : encrypts a value with the current key
+func (k *Keyring) Encrypt(s string) (string, error) {
+	if s == "" {
+		return s, nil
+	}
+
+	if k.Current == "" {
+		return aes.New().Encrypt(s, k.Legacy)
+	}
+
+	key, ok := k.Keys[k.Current]
+	if !ok {
+		return "", errors.New("current crypto key '" + k.Current + "' is not in the keyring")
+	}
+
+	x, err := aes.New().Encrypt(s, key)
+	if err != nil {
+		return "", err
+	}
+
+	return k.Current + ":" + x, nil
+}
+
+// Decrypt : decrypts a value with the key it was encrypted with
+func (k *Keyring) Decrypt(s string) (string, error) {
+	if s == "" {
+		return s, nil
+	}
+
+	id, x := splitKeyID(s)
+	if id == "" {
+		return aes.New().Decrypt(x, k.Legacy)
+	}
+
+	key, ok := k.Keys[id]
+	if !ok {
+		return "", errors.New("unknown crypto key '" + id + "'")
+	}
+
+	return aes.New().Decrypt(x, key)
+}
+
+// IsCurrent : checks if a value is encrypted with the current key
+func (k *Keyring) IsCurrent(s string) bool {
+	id, _ := splitKeyID(s)
+	return id == k.Current
+}
+
+// Wrap : wraps a data key with the current key
+func (k *Keyring) Wrap(key string) (string, error) {
+	return k.Encrypt(key)
+}
+
+// Unwrap : unwraps a data key
+func (k *Keyring) Unwrap(wrapped string) (string, error) {
+	return k.Decrypt(wrapped)
+}
+
+// CurrentKey : the id of the key new values are encrypted with
+func (k *Keyring) CurrentKey() string {
+	return k.Current
+}
+
+// splitKeyID : splits a value into its key id and ciphertext. The
+// ciphertext is base64 encoded, so never contains a ':'
+func splitKeyID(s string) (string, string) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return "", s
+	}
+	return s[:i], s[i+1:]
+}
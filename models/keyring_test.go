@@ -0,0 +1,81 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	_ = os.Setenv("ERNEST_CRYPTO_KEY", "mMYlPIvI11z20H1BnBmB223355667788")
+	_ = os.Setenv("ERNEST_CRYPTO_KEY_ID", "")
+	_ = os.Setenv("ERNEST_CRYPTO_KEYS", "k2=aaaaaaaaaaaaaaaabbbbbbbbbbbbbbbb")
+	defer os.Unsetenv("ERNEST_CRYPTO_KEY_ID")
+	defer os.Unsetenv("ERNEST_CRYPTO_KEYS")
+
+	legacy, err := LoadKeyring().Encrypt("secret")
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(legacy, ":"))
+
+	_ = os.Setenv("ERNEST_CRYPTO_KEY_ID", "k2")
+	k := LoadKeyring()
+	p := &KeyringProvider{Keyring: k}
+
+	c := Map{"region": "eu-west-1", "secret_access_key": legacy}
+	e := &Environment{Credentials: c}
+
+	changed, err := rotateCredentials(p, e)
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "eu-west-1", e.Credentials["region"])
+	assert.True(t, strings.HasPrefix(e.Credentials["secret_access_key"].(string), "enc:k2:"))
+
+	plain, err := unseal(p, e, e.Credentials["secret_access_key"].(string))
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", plain)
+
+	changed, err = rotateCredentials(p, e)
+	assert.Nil(t, err)
+	assert.False(t, changed)
+
+	_, err = k.Decrypt("k9:abc")
+	assert.NotNil(t, err)
+}
+
+func TestEnvelopeProvider(t *testing.T) {
+	master := &Keyring{Current: "m1", Keys: map[string]string{"m1": "aaaaaaaaaaaaaaaabbbbbbbbbbbbbbbb"}}
+	p := &EnvelopeProvider{Master: master, Fallback: &KeyringProvider{Keyring: master}}
+
+	e1 := &Environment{}
+	e2 := &Environment{}
+
+	x1, err := p.Encrypt(e1, "secret")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(x1, envelopePrefix))
+	assert.True(t, strings.HasPrefix(e1.DataKey, "m1:"))
+
+	_, err = p.Encrypt(e2, "secret")
+	assert.Nil(t, err)
+	assert.NotEqual(t, e1.DataKey, e2.DataKey)
+
+	other, _ := p.Decrypt(e2, x1)
+	assert.NotEqual(t, "secret", other)
+
+	master.Keys["m2"] = "ccccccccccccccccdddddddddddddddd"
+	master.Current = "m2"
+
+	changed, err := p.Rewrap(e1)
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.True(t, strings.HasPrefix(e1.DataKey, "m2:"))
+
+	plain, err := p.Decrypt(e1, x1)
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", plain)
+}
@@ -0,0 +1,52 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+)
+
+// List : holds a []string value that can be loaded/serialized to a JSONB field
+type List []string
+
+// Value : returns a valid []byte json array
+func (l List) Value() (driver.Value, error) {
+	if l == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(l)
+}
+
+// Scan : serializes the jsonb array to a []string
+func (l *List) Scan(src interface{}) error {
+	var source []byte
+
+	switch src.(type) {
+	case string:
+		source = []byte(src.(string))
+	case []byte:
+		source = src.([]byte)
+	default:
+		return errors.New("type assertion .([]byte) & .(string) failed")
+	}
+
+	if string(source) == "null" {
+		source = []byte("[]")
+	}
+
+	return json.Unmarshal(source, l)
+}
+
+// Contains : checks if the list contains a value
+func (l List) Contains(s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
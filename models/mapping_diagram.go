@@ -0,0 +1,198 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Mapping diagram formats
+const (
+	FormatDOT     = "dot"
+	FormatMermaid = "mermaid"
+)
+
+// StateColours : the colour of the components of a diagram by their state
+var StateColours = map[string]string{
+	"completed": "#8fd694",
+	"running":   "#f7d96b",
+	"waiting":   "#d9d9d9",
+	"errored":   "#f28b82",
+	"failed":    "#f28b82",
+}
+
+// DefaultStateColour : the colour of components whose state has no colour
+const DefaultStateColour = "#ffffff"
+
+// diagramNode : a component of a diagram
+type diagramNode struct {
+	id    string
+	label string
+	kind  string
+	state string
+}
+
+// Diagram : renders the components and edges of a mapping as a Graphviz DOT
+// digraph or a Mermaid flowchart. Components are grouped by their type and
+// coloured by their state
+func (m Map) Diagram(format string) (string, error) {
+	nm, err := normaliseMapping(m)
+	if err != nil {
+		return "", err
+	}
+
+	nodes, kinds := diagramNodes(nm["components"])
+
+	var edges []Edge
+	for _, e := range sortedEdges(edgeSet(nm["edges"])) {
+		if nodes[e.Source] != nil && nodes[e.Destination] != nil {
+			edges = append(edges, e)
+		}
+	}
+
+	name, _ := nm["id"].(string)
+
+	switch format {
+	case FormatDOT:
+		return dot(name, kinds, edges), nil
+	case FormatMermaid:
+		return mermaid(kinds, edges), nil
+	}
+
+	return "", errors.New("unsupported mapping format " + format)
+}
+
+// diagramNodes : the components of a mapping by id, and grouped by their
+// type in order of the type's name
+func diagramNodes(v interface{}) (map[string]*diagramNode, [][]*diagramNode) {
+	var names []string
+
+	nodes := make(map[string]*diagramNode)
+	byKind := make(map[string][]*diagramNode)
+
+	list, _ := v.([]interface{})
+
+	for _, x := range list {
+		c, ok := x.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		n := diagramNode{}
+		n.id, _ = c["_component_id"].(string)
+		n.label, _ = c["name"].(string)
+		n.kind, _ = c["_component"].(string)
+		n.state, _ = c["_state"].(string)
+
+		if n.id == "" || nodes[n.id] != nil {
+			continue
+		}
+
+		if n.label == "" {
+			n.label = n.id
+		}
+
+		if n.kind == "" {
+			n.kind = strings.Split(n.id, "::")[0]
+		}
+
+		if byKind[n.kind] == nil {
+			names = append(names, n.kind)
+		}
+
+		nodes[n.id] = &n
+		byKind[n.kind] = append(byKind[n.kind], &n)
+	}
+
+	sort.Strings(names)
+
+	kinds := make([][]*diagramNode, len(names))
+	for i, name := range names {
+		kinds[i] = byKind[name]
+	}
+
+	return nodes, kinds
+}
+
+func colour(state string) string {
+	if c, ok := StateColours[state]; ok {
+		return c
+	}
+	return DefaultStateColour
+}
+
+func dot(name string, kinds [][]*diagramNode, edges []Edge) string {
+	var b bytes.Buffer
+
+	quote := func(s string) string {
+		return `"` + strings.Replace(strings.Replace(s, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+	}
+
+	fmt.Fprintf(&b, "digraph %s {\n", quote(name))
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=filled];\n")
+
+	for _, nodes := range kinds {
+		kind := nodes[0].kind
+
+		fmt.Fprintf(&b, "  subgraph %s {\n", quote("cluster_"+kind))
+		fmt.Fprintf(&b, "    label=%s;\n", quote(kind))
+
+		for _, n := range nodes {
+			fmt.Fprintf(&b, "    %s [label=%s, fillcolor=%s];\n", quote(n.id), quote(n.label), quote(colour(n.state)))
+		}
+
+		b.WriteString("  }\n")
+	}
+
+	for _, e := range edges {
+		fmt.Fprintf(&b, "  %s -> %s;\n", quote(e.Source), quote(e.Destination))
+	}
+
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+func mermaid(kinds [][]*diagramNode, edges []Edge) string {
+	var b bytes.Buffer
+
+	// mermaid ids can't hold the characters component ids do, so nodes are
+	// numbered and labelled with their name
+	ids := make(map[string]string)
+
+	quote := func(s string) string {
+		return `"` + strings.Replace(s, `"`, "#quot;", -1) + `"`
+	}
+
+	b.WriteString("flowchart LR\n")
+
+	for i, nodes := range kinds {
+		fmt.Fprintf(&b, "  subgraph t%d [%s]\n", i, quote(nodes[0].kind))
+
+		for _, n := range nodes {
+			ids[n.id] = fmt.Sprintf("n%d", len(ids))
+			fmt.Fprintf(&b, "    %s[%s]\n", ids[n.id], quote(n.label))
+		}
+
+		b.WriteString("  end\n")
+	}
+
+	for _, e := range edges {
+		fmt.Fprintf(&b, "  %s --> %s\n", ids[e.Source], ids[e.Destination])
+	}
+
+	for _, nodes := range kinds {
+		for _, n := range nodes {
+			fmt.Fprintf(&b, "  style %s fill:%s\n", ids[n.id], colour(n.state))
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,64 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMappingDiagram(t *testing.T) {
+	m := testMapping(t, `{
+		"id": "uuid-1",
+		"components": [
+			{"_component_id": "network::a", "_component": "network", "name": "a", "_state": "completed"},
+			{"_component_id": "instance::web", "_component": "instance", "name": "web", "_state": "errored"},
+			{"_component_id": "firewall::b", "name": "b"}
+		],
+		"edges": [
+			{"source": "network::a", "destination": "instance::web"},
+			{"source": "network::a", "destination": "network::missing"}
+		]
+	}`)
+
+	t.Run("dot", func(t *testing.T) {
+		d, err := m.Diagram(FormatDOT)
+		assert.Nil(t, err)
+		assert.Contains(t, d, `digraph "uuid-1" {`)
+		assert.Contains(t, d, `subgraph "cluster_network" {`)
+		assert.Contains(t, d, `subgraph "cluster_firewall" {`)
+		assert.Contains(t, d, `"network::a" [label="a", fillcolor="#8fd694"];`)
+		assert.Contains(t, d, `"instance::web" [label="web", fillcolor="#f28b82"];`)
+		assert.Contains(t, d, `"firewall::b" [label="b", fillcolor="#ffffff"];`)
+		assert.Contains(t, d, `"network::a" -> "instance::web";`)
+		assert.NotContains(t, d, "network::missing")
+	})
+
+	t.Run("mermaid", func(t *testing.T) {
+		d, err := m.Diagram(FormatMermaid)
+		assert.Nil(t, err)
+		assert.Equal(t, `flowchart LR
+  subgraph t0 ["firewall"]
+    n0["b"]
+  end
+  subgraph t1 ["instance"]
+    n1["web"]
+  end
+  subgraph t2 ["network"]
+    n2["a"]
+  end
+  n2 --> n1
+  style n0 fill:#ffffff
+  style n1 fill:#f28b82
+  style n2 fill:#8fd694
+`, d)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := m.Diagram("svg")
+		assert.NotNil(t, err)
+	})
+}
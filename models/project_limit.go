@@ -0,0 +1,162 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// projectLockClass : namespaces the advisory locks taken on projects
+const projectLockClass = 2901
+
+// ProjectLimit : stores the maximum number of concurrent builds of a project
+type ProjectLimit struct {
+	ProjectID uint      `json:"project_id" gorm:"primary_key"`
+	MaxBuilds int       `json:"max_builds"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Scope     *Scope    `json:"_scope,omitempty" gorm:"-" sql:"-"`
+}
+
+// ProjectUsage : the running builds of a project against its limit
+type ProjectUsage struct {
+	ProjectID    uint     `json:"project_id"`
+	MaxBuilds    int      `json:"max_builds"`
+	Running      int      `json:"running"`
+	Environments []string `json:"environments"`
+}
+
+// ProjectBusyError : returned when a project has reached its concurrent build limit
+type ProjectBusyError struct {
+	ProjectID uint
+	MaxBuilds int
+	Running   int
+}
+
+// Error ...
+func (e *ProjectBusyError) Error() string {
+	return fmt.Sprintf("could not create environment build: project busy (%d of %d builds running)", e.Running, e.MaxBuilds)
+}
+
+// Code : identifies the error type to callers
+func (e *ProjectBusyError) Code() string {
+	return "project_busy"
+}
+
+// TableName : set Entity's table name to be project_limits
+func (l *ProjectLimit) TableName() string {
+	return "project_limits"
+}
+
+// DefaultMaxBuilds : the project build limit used when a project has none set,
+// read from ERNEST_PROJECT_MAX_BUILDS. Zero means unlimited
+func DefaultMaxBuilds() int {
+	max, _ := strconv.Atoi(os.Getenv("ERNEST_PROJECT_MAX_BUILDS"))
+	return max
+}
+
+// Set : creates or updates a project's limit
+func (l *ProjectLimit) Set() error {
+	err := l.Scope.Check(l.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	return DB.Save(l).Error
+}
+
+// GetProjectUsage : gets the current usage of the given projects, or of every
+// project with an environment if none are given
+func GetProjectUsage(ids []uint) ([]ProjectUsage, error) {
+	var envs []Environment
+	var limits []ProjectLimit
+
+	usage := []ProjectUsage{}
+
+	if len(ids) < 1 {
+		err := DB.Model(&Environment{}).Pluck("DISTINCT project_id", &ids).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := DB.Where("project_id in (?)", ids).Find(&limits).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = DB.Select("name, project_id").Where("project_id in (?) AND status in (?)", ids, RunningStates).Find(&envs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		u := ProjectUsage{ProjectID: id, MaxBuilds: DefaultMaxBuilds(), Environments: []string{}}
+
+		for _, l := range limits {
+			if l.ProjectID == id {
+				u.MaxBuilds = l.MaxBuilds
+			}
+		}
+
+		for _, e := range envs {
+			if e.ProjectID == id {
+				u.Running++
+				u.Environments = append(u.Environments, e.Name)
+			}
+		}
+
+		usage = append(usage, u)
+	}
+
+	return usage, nil
+}
+
+// checkProjectLimit : returns a ProjectBusyError if starting a build on the
+// environment would exceed its project's limit. The project is locked until
+// the transaction ends so concurrent builds are counted correctly
+func checkProjectLimit(tx *gorm.DB, e *Environment) error {
+	var l ProjectLimit
+	var running int
+
+	if List(RunningStates).Contains(e.Status) {
+		return nil
+	}
+
+	max := DefaultMaxBuilds()
+
+	err := tx.Where("project_id = ?", e.ProjectID).First(&l).Error
+	switch {
+	case err == nil:
+		max = l.MaxBuilds
+	case err != gorm.ErrRecordNotFound:
+		return err
+	}
+
+	if max < 1 {
+		return nil
+	}
+
+	err = tx.Exec("SELECT pg_advisory_xact_lock(?, ?)", projectLockClass, e.ProjectID).Error
+	if err != nil {
+		return err
+	}
+
+	err = tx.Model(&Environment{}).Where("project_id = ? AND id != ? AND status in (?)", e.ProjectID, e.ID, RunningStates).Count(&running).Error
+	if err != nil {
+		return err
+	}
+
+	if running >= max {
+		return &ProjectBusyError{ProjectID: e.ProjectID, MaxBuilds: max, Running: running}
+	}
+
+	return nil
+}
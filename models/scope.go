@@ -0,0 +1,165 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package models
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ScopeField : the request field that carries the caller's scope
+const ScopeField = "_scope"
+
+// Scope : the projects a caller can read and modify. It is set from the
+// verified caller of a request, and only the requests of trusted callers, or
+// of callers when scoping isn't required, are left without one
+type Scope struct {
+	ProjectIDs []uint `json:"project_ids"`
+}
+
+// ScopeError : a change to a project outside of the caller's scope
+type ScopeError struct {
+	ProjectID uint
+}
+
+func (e *ScopeError) Error() string {
+	return "project " + strconv.Itoa(int(e.ProjectID)) + " is outside of the caller's scope"
+}
+
+// Code ...
+func (e *ScopeError) Code() string {
+	return "forbidden"
+}
+
+// ScopeOf : removes the caller's scope from a query, returning it
+func ScopeOf(q map[string]interface{}) *Scope {
+	v, ok := q[ScopeField]
+	if !ok {
+		return nil
+	}
+
+	delete(q, ScopeField)
+
+	switch x := v.(type) {
+	case *Scope:
+		return x
+	case Scope:
+		return &x
+	case nil:
+		return nil
+	}
+
+	var s Scope
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return &s
+	}
+
+	// a malformed scope allows nothing
+	_ = json.Unmarshal(data, &s)
+
+	return &s
+}
+
+// Restricted : checks if the scope limits the caller to some projects
+func (s *Scope) Restricted() bool {
+	return s != nil
+}
+
+// Allows : checks if the caller can access a project
+func (s *Scope) Allows(projectID uint) bool {
+	if !s.Restricted() {
+		return true
+	}
+
+	for _, id := range s.ProjectIDs {
+		if id == projectID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check : returns an error if the caller can't access the project
+func (s *Scope) Check(projectID uint) error {
+	if !s.Allows(projectID) {
+		return &ScopeError{ProjectID: projectID}
+	}
+	return nil
+}
+
+// CheckEnvironment : returns an error if the caller can't access the project
+// of an environment
+func (s *Scope) CheckEnvironment(db *gorm.DB, id uint) error {
+	var env Environment
+
+	if !s.Restricted() {
+		return nil
+	}
+
+	err := db.Select("id, project_id").Where("id = ?", id).First(&env).Error
+	if err != nil {
+		return err
+	}
+
+	return s.Check(env.ProjectID)
+}
+
+// Projects : returns the given projects the caller can access, or all of the
+// caller's projects if none are given
+func (s *Scope) Projects(ids []uint) []uint {
+	allowed := []uint{}
+
+	if len(ids) < 1 {
+		return append(allowed, s.ProjectIDs...)
+	}
+
+	for _, id := range ids {
+		if s.Allows(id) {
+			allowed = append(allowed, id)
+		}
+	}
+
+	return allowed
+}
+
+// checkFreeze : returns an error if the caller can't access the project or
+// the environment a freeze applies to
+func (s *Scope) checkFreeze(f *Freeze) error {
+	if f.EnvironmentID != 0 {
+		return s.CheckEnvironment(DB, f.EnvironmentID)
+	}
+	return s.Check(f.ProjectID)
+}
+
+// apply : limits a query on an entity with the given fields to the rows of
+// the caller's projects. Entities that belong to environments, such as
+// builds, are limited to the ones of environments of those projects
+func (s *Scope) apply(db *gorm.DB, fields []string) *gorm.DB {
+	if !s.Restricted() {
+		return db
+	}
+
+	if len(s.ProjectIDs) < 1 {
+		return db.Where("1 = 0")
+	}
+
+	environments := "environment_id in (SELECT id FROM environments WHERE project_id in (?))"
+
+	switch {
+	case supported("project_id", fields) && supported("environment_id", fields):
+		return db.Where("(project_id in (?) OR "+environments+")", s.ProjectIDs, s.ProjectIDs)
+	case supported("project_id", fields):
+		return db.Where("project_id in (?)", s.ProjectIDs)
+	case supported("environment_id", fields):
+		return db.Where(environments, s.ProjectIDs)
+	}
+
+	return db
+}
@@ -0,0 +1,57 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProjectLimit(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Build    *models.Build
+		Expected string
+	}{
+		{"first", &models.Build{EnvironmentID: uint(1), Type: "apply"}, "in_progress"},
+		{"over-limit", &models.Build{EnvironmentID: uint(2), Type: "apply"}, "project_busy"},
+		{"other-project", &models.Build{EnvironmentID: uint(4), Type: "apply"}, "in_progress"},
+	}
+
+	setupTestSuite("test_project_limit")
+
+	db.Unscoped().Delete(models.Environment{}, models.Build{})
+	db.Unscoped().Delete(models.ProjectLimit{})
+	CreateTestData(db, 20)
+
+	db.Exec("UPDATE environments SET project_id = 5 WHERE id IN (1, 2, 3)")
+
+	resp, err := n.Request("project.set.limit", []byte(`{"project_id": 5, "max_builds": 1}`), time.Second)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(resp.Data), "error")
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			data, _ := json.Marshal(tc.Build)
+			resp, err := n.Request("build.set", data, time.Second)
+			assert.Nil(t, err)
+			assert.Contains(t, string(resp.Data), tc.Expected)
+		})
+	}
+
+	var usage []models.ProjectUsage
+
+	resp, err = n.Request("project.find.usage", []byte(`{"project_id": 5}`), time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(resp.Data, &usage))
+	assert.Len(t, usage, 1)
+	assert.Equal(t, 1, usage[0].MaxBuilds)
+	assert.Equal(t, 1, usage[0].Running)
+	assert.Equal(t, []string{"Test1"}, usage[0].Environments)
+}
@@ -0,0 +1,109 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ernestio/service-store/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProjectScope(t *testing.T) {
+	_ = os.Setenv("ERNEST_AUTH_KEYS", "api=api-secret,tenant=tenant-secret,monitor=monitor-secret")
+	_ = os.Setenv("ERNEST_AUTH_SCOPES", "tenant=1")
+	_ = os.Setenv("ERNEST_AUTH_TRUSTED", "api")
+	defer os.Unsetenv("ERNEST_AUTH_KEYS")
+	defer os.Unsetenv("ERNEST_AUTH_SCOPES")
+	defer os.Unsetenv("ERNEST_AUTH_TRUSTED")
+
+	setupTestSuite("test_project_scope")
+
+	db.Unscoped().Delete(models.Environment{}, models.Build{})
+	CreateTestData(db, 20)
+
+	db.Model(&models.Environment{}).Where("id in (?)", []uint{1, 2}).Update("project_id", 1)
+	db.Model(&models.Environment{}).Where("id > ?", 2).Update("project_id", 2)
+
+	t.Run("find", func(t *testing.T) {
+		var builds []models.Build
+
+		find := func(t *testing.T, data []byte) int {
+			var envs []models.Environment
+
+			resp, err := n.Request("environment.find", data, time.Second)
+			assert.Nil(t, err)
+			assert.Nil(t, json.Unmarshal(resp.Data, &envs))
+
+			return len(envs)
+		}
+
+		assert.Equal(t, 2, find(t, signed("tenant", "environment.find", []byte(`{}`))))
+
+		resp, err := n.Request("build.find", signed("tenant", "build.find", []byte(`{}`)), time.Second)
+		assert.Nil(t, err)
+		assert.Nil(t, json.Unmarshal(resp.Data, &builds))
+		assert.Equal(t, 2, len(builds))
+
+		// the scope a scoped caller sends is ignored
+		assert.Equal(t, 2, find(t, signed("tenant", "environment.find", []byte(`{"_scope": {"project_ids": [2]}}`))))
+		assert.Equal(t, 2, find(t, signed("tenant", "environment.find", []byte(`{"_scope": {"project_ids": [1], "role": "admin"}}`))))
+
+		// trusted callers aren't restricted, unless they pass on a scope
+		assert.Equal(t, 20, find(t, signed("api", "environment.find", []byte(`{}`))))
+		assert.Equal(t, 2, find(t, signed("api", "environment.find", []byte(`{"_scope": {"project_ids": [1]}}`))))
+		assert.Equal(t, 0, find(t, signed("api", "environment.find", []byte(`{"_scope": {"project_ids": []}}`))))
+	})
+
+	t.Run("unscoped-callers", func(t *testing.T) {
+		resp, err := n.Request("environment.find", []byte(`{"_scope": {"project_ids": [1], "role": "admin"}}`), time.Second)
+		assert.Nil(t, err)
+		assert.Contains(t, string(resp.Data), "must be signed")
+
+		resp, err = n.Request("environment.find", signed("monitor", "environment.find", []byte(`{}`)), time.Second)
+		assert.Nil(t, err)
+		assert.Contains(t, string(resp.Data), "monitor has no scope")
+
+		// requests that don't expect a reply are refused too
+		assert.Nil(t, n.Publish("environment.del", []byte(`{"id": 5}`)))
+		time.Sleep(100 * time.Millisecond)
+
+		var count int
+		db.Model(&models.Environment{}).Where("id = ?", 5).Count(&count)
+		assert.Equal(t, 1, count)
+	})
+
+	cases := []struct {
+		Name     string
+		Subject  string
+		Data     string
+		Expected string
+	}{
+		{"get-in-scope", "environment.get", `{"id": 1}`, `"name":"Test1"`},
+		{"get-out-of-scope", "environment.get", `{"id": 3}`, "not found"},
+		{"get-build-out-of-scope", "build.get", `{"id": "uuid-3"}`, "not found"},
+		{"get-mapping-out-of-scope", "build.get.mapping", `{"id": "uuid-3"}`, "not found"},
+		{"update-out-of-scope", "environment.set", `{"id": 3, "options": {"sync": false}}`, "forbidden"},
+		{"create-out-of-scope", "environment.set", `{"name": "Test100", "project_id": 2}`, "forbidden"},
+		{"delete-out-of-scope", "environment.del", `{"id": 3}`, "forbidden"},
+		{"set-status-out-of-scope", "build.set.status", `{"id": "uuid-3", "status": "errored"}`, "forbidden"},
+		{"set-status-in-scope", "build.set.status", `{"id": "uuid-1", "status": "errored"}`, `"status": "ok"`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resp, err := n.Request(tc.Subject, signed("tenant", tc.Subject, []byte(tc.Data)), time.Second)
+			assert.Nil(t, err)
+			assert.Contains(t, string(resp.Data), tc.Expected)
+		})
+	}
+
+	var e models.Environment
+	db.Where("id = ?", 3).First(&e)
+	assert.Equal(t, true, e.Options["sync"])
+}
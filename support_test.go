@@ -69,6 +69,16 @@ func CreateTestData(db *gorm.DB, count int) {
 	}
 }
 
+var nonces int
+
+// signed : wraps a request in an envelope signed by the caller, whose secret
+// is its identity followed by -secret
+func signed(identity, subject string, payload []byte) []byte {
+	nonces++
+	data, _ := handlers.Sign(identity, identity+"-secret", subject, "nonce-"+strconv.Itoa(nonces), payload)
+	return data
+}
+
 func setupTestSuite(database string) {
 	n = akira.NewFakeConnector()
 	handlers.NC = n
@@ -82,7 +92,7 @@ func setupTestSuite(database string) {
 
 	_ = tests.CreateTestDB(database)
 	setupPg(database)
-	db.AutoMigrate(models.Environment{}, models.Build{})
+	db.AutoMigrate(models.Environment{}, models.Build{}, models.Freeze{}, models.ProjectLimit{}, models.CredentialSet{}, models.AuditRecord{}, models.DeployedState{}, models.BuildComponent{})
 
 	startHandler()
 }
@@ -27,7 +27,7 @@ func TestSetComponentHandler(t *testing.T) {
 	})
 
 	setupPg("test_transactions")
-	db.AutoMigrate(models.Environment{}, models.Build{})
+	db.AutoMigrate(models.Environment{}, models.Build{}, models.BuildComponent{})
 
 	startHandler()
 
@@ -54,6 +54,7 @@ func TestSetComponentHandler(t *testing.T) {
 			Convey("It should update both the components", func() {
 				var b models.Build
 				db.Where("uuid = ?", id).First(&b)
+				So(b.LoadMapping(), ShouldBeNil)
 
 				g := graph.New()
 				So(g.Load(b.Mapping), ShouldBeNil)
@@ -81,6 +82,7 @@ func TestSetComponentHandler(t *testing.T) {
 			Convey("It should update both the components", func() {
 				var b models.Build
 				db.Where("uuid = ?", id).First(&b)
+				So(b.LoadMapping(), ShouldBeNil)
 
 				g := graph.New()
 				So(g.Load(b.Mapping), ShouldBeNil)